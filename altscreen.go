@@ -0,0 +1,99 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "os/signal"
+  "strings"
+  "syscall"
+
+  "golang.org/x/term"
+)
+
+// Terminal sequences used by the alternate screen viewer.
+const (
+  enterAltScreen = "\x1b[?1049h\x1b[?7l\x1b[?25l"
+  leaveAltScreen = "\x1b[?25h\x1b[?7h\x1b[?1049l"
+  clearScreen    = "\x1b[H\x1b[2J"
+)
+
+// altScreenHeight returns the terminal height if rendered output should go
+// to the alternate screen: both stdin and stdout are terminals and the
+// output is taller than the screen.
+func altScreenHeight(out string) (int, bool) {
+  if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+    return 0, false
+  }
+  _, height, err := term.GetSize(int(os.Stdout.Fd()))
+  if err != nil || height < 3 {
+    return 0, false
+  }
+  return height, strings.Count(out, "\n") >= height
+}
+
+// viewInAltScreen shows lines in the alternate screen buffer with less-like
+// keys until the user quits, then returns to the normal screen. The terminal
+// is restored on Ctrl-C, and on SIGTERM or SIGHUP before exiting.
+func viewInAltScreen(lines []string, height int) error {
+  fd := int(os.Stdin.Fd())
+  state, err := term.MakeRaw(fd)
+  if err != nil {
+    return err
+  }
+  restore := func() {
+    fmt.Print(leaveAltScreen)
+    term.Restore(fd, state)
+  }
+  defer restore()
+
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+  defer func() {
+    signal.Stop(signals)
+    close(signals)
+  }()
+  go func() {
+    if _, ok := <-signals; ok {
+      restore()
+      os.Exit(1)
+    }
+  }()
+
+  fmt.Print(enterAltScreen)
+  page := height - 1
+  top := 0
+  last := max(len(lines)-page, 0)
+  key := make([]byte, 8)
+  for {
+    var screen strings.Builder
+    screen.WriteString(clearScreen)
+    for _, line := range lines[top:min(top+page, len(lines))] {
+      screen.WriteString(line + "\r\n")
+    }
+    status := fmt.Sprintf("lines %d-%d of %d  space/b page, j/k scroll, g/G top/bottom, q quit", top+1, min(top+page, len(lines)), len(lines))
+    screen.WriteString(dimStyle.Render(status))
+    fmt.Print(screen.String())
+
+    n, err := os.Stdin.Read(key)
+    if err != nil {
+      return err
+    }
+    switch string(key[:n]) {
+    case "q", "Q", "\x1b", "\x03":
+      return nil
+    case " ", "f", "\x1b[6~":
+      top += page
+    case "b", "\x1b[5~":
+      top -= page
+    case "j", "\r", "\x1b[B":
+      top++
+    case "k", "\x1b[A":
+      top--
+    case "g", "\x1b[H":
+      top = 0
+    case "G", "\x1b[F":
+      top = last
+    }
+    top = max(min(top, last), 0)
+  }
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log/slog"
+  "net/http"
+  "strings"
+  "sync"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+const (
+  anthropicBaseURL = "https://api.anthropic.com/v1"
+  anthropicVersion = "2023-06-01"
+  // anthropicMaxTokens is sent when Config.MaxTokens is unset, because the
+  // messages API requires a limit.
+  anthropicMaxTokens = 4096
+  // anthropicMaxTemperature is the top of the messages API's temperature
+  // range; OpenAI's goes up to 2.
+  anthropicMaxTemperature = 1
+)
+
+// anthropicProvider sends conversations to Anthropic's messages API.
+type anthropicProvider struct {
+  apiKey  string
+  baseURL string
+  http    *http.Client
+
+  // warned records the settings already warned about, so each is logged
+  // once rather than on every request.
+  mu     sync.Mutex
+  warned map[string]bool
+}
+
+func newAnthropicProvider(apiKey, baseURL string) *anthropicProvider {
+  if baseURL == "" {
+    baseURL = anthropicBaseURL
+  }
+  return &anthropicProvider{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), http: http.DefaultClient}
+}
+
+type anthropicMessage struct {
+  Role    string `json:"role"`
+  Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+  Model       string             `json:"model"`
+  MaxTokens   int                `json:"max_tokens"`
+  System      string             `json:"system,omitempty"`
+  Messages    []anthropicMessage `json:"messages"`
+  Temperature *float32           `json:"temperature,omitempty"`
+  TopP        *float32           `json:"top_p,omitempty"`
+  Stop        []string           `json:"stop_sequences,omitempty"`
+}
+
+type anthropicResponse struct {
+  Content []struct {
+    Type string `json:"type"`
+    Text string `json:"text"`
+  } `json:"content"`
+  Usage struct {
+    InputTokens  int `json:"input_tokens"`
+    OutputTokens int `json:"output_tokens"`
+  } `json:"usage"`
+}
+
+// anthropicError is an error response from the messages API. Its status
+// code is what retries and fallback models go by, as for OpenAI errors.
+type anthropicError struct {
+  HTTPStatusCode int
+  Type           string
+  Message        string
+}
+
+func (e *anthropicError) Error() string {
+  if e.Message == "" {
+    return fmt.Sprintf("anthropic: status %d", e.HTTPStatusCode)
+  }
+  return fmt.Sprintf("anthropic: %s (status %d): %s", e.Type, e.HTTPStatusCode, e.Message)
+}
+
+// warnOnce logs msg the first time it is passed for setting.
+func (p *anthropicProvider) warnOnce(setting, msg string, args ...any) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  if p.warned[setting] {
+    return
+  }
+  if p.warned == nil {
+    p.warned = make(map[string]bool)
+  }
+  p.warned[setting] = true
+  slog.Warn(msg, args...)
+}
+
+func (p *anthropicProvider) complete(ctx context.Context, messages []openai.ChatCompletionMessage, config Config) (completion, error) {
+  system, turns := anthropicMessages(messages)
+  request := anthropicRequest{
+    Model:       config.Model,
+    MaxTokens:   config.MaxTokens,
+    System:      system,
+    Messages:    turns,
+    Temperature: config.Temperature,
+    TopP:        config.TopP,
+    Stop:        config.Stop,
+  }
+  if t := config.Temperature; t != nil && *t > anthropicMaxTemperature {
+    p.warnOnce("temperature", "anthropic temperatures go up to 1; sending 1", "temperature", *t)
+    clamped := float32(anthropicMaxTemperature)
+    request.Temperature = &clamped
+  }
+  // The messages API has no equivalent of these, so say they aren't applied
+  // rather than drop them silently.
+  if config.PresencePenalty != 0 || config.FrequencyPenalty != 0 {
+    p.warnOnce("penalties", "presence_penalty and frequency_penalty are ignored with the anthropic provider")
+  }
+  if config.Seed != nil {
+    p.warnOnce("seed", "seed is ignored with the anthropic provider")
+  }
+  if config.ResponseFormat != "" || config.ResponseSchema != "" {
+    p.warnOnce("response_format", "response_format and response_schema are not sent with the anthropic provider")
+  }
+  if request.MaxTokens == 0 {
+    request.MaxTokens = anthropicMaxTokens
+  }
+  body, err := json.Marshal(request)
+  if err != nil {
+    return completion{}, err
+  }
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/messages", bytes.NewReader(body))
+  if err != nil {
+    return completion{}, err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Api-Key", p.apiKey)
+  req.Header.Set("Anthropic-Version", anthropicVersion)
+
+  resp, err := p.http.Do(req)
+  if err != nil {
+    return completion{}, err
+  }
+  defer resp.Body.Close()
+  data, err := io.ReadAll(resp.Body)
+  if err != nil {
+    return completion{}, err
+  }
+
+  if resp.StatusCode != http.StatusOK {
+    apiErr := &anthropicError{HTTPStatusCode: resp.StatusCode}
+    var errResp struct {
+      Error struct {
+        Type    string `json:"type"`
+        Message string `json:"message"`
+      } `json:"error"`
+    }
+    if json.Unmarshal(data, &errResp) == nil {
+      apiErr.Type, apiErr.Message = errResp.Error.Type, errResp.Error.Message
+    }
+    return completion{}, apiErr
+  }
+
+  var parsed anthropicResponse
+  if err := json.Unmarshal(data, &parsed); err != nil {
+    return completion{}, fmt.Errorf("parsing anthropic response: %w", err)
+  }
+  var content strings.Builder
+  for _, block := range parsed.Content {
+    if block.Type == "text" {
+      content.WriteString(block.Text)
+    }
+  }
+  return completion{
+    content: content.String(),
+    usage: openai.Usage{
+      PromptTokens:     parsed.Usage.InputTokens,
+      CompletionTokens: parsed.Usage.OutputTokens,
+      TotalTokens:      parsed.Usage.InputTokens + parsed.Usage.OutputTokens,
+    },
+  }, nil
+}
+
+// anthropicMessages converts a conversation to the messages API's shape:
+// system messages become the separate system prompt, and consecutive
+// messages from the same role, such as added files followed by a question,
+// are joined into one turn.
+func anthropicMessages(messages []openai.ChatCompletionMessage) (string, []anthropicMessage) {
+  var system []string
+  var turns []anthropicMessage
+  for _, msg := range messages {
+    text := messageText(msg)
+    if msg.Role == openai.ChatMessageRoleSystem {
+      if text != "" {
+        system = append(system, text)
+      }
+      continue
+    }
+    if n := len(turns); n > 0 && turns[n-1].Role == msg.Role {
+      turns[n-1].Content += "\n\n" + text
+      continue
+    }
+    turns = append(turns, anthropicMessage{Role: msg.Role, Content: text})
+  }
+  return strings.Join(system, "\n\n"), turns
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/fs"
+  "log/slog"
+  "net/http"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+const (
+  modeChat =      "chat"
+  modeAssistant = "assistant"
+)
+
+// runPollInterval is how often an in-progress assistant run is checked.
+const runPollInterval = 500 * time.Millisecond
+
+// assistantThread mirrors the local message history into a server-side
+// Assistants API thread. Only messages the thread has not seen yet are sent,
+// so the interactive loop can keep working with a plain messages slice.
+type assistantThread struct {
+  client      *openai.Client
+  assistantID string
+  threadID    string
+  // synced is the local history the thread already holds, in order.
+  synced      []openai.ChatCompletionMessage
+}
+
+func newAssistantThread(ctx context.Context, client *openai.Client, config Config) (*assistantThread, error) {
+  assistantID := config.AssistantID
+  if assistantID != "" {
+    if _, err := client.RetrieveAssistant(ctx, assistantID); err != nil {
+      return nil, fmt.Errorf("retrieving assistant %s: %w", assistantID, err)
+    }
+  } else {
+    var err error
+    assistantID, err = savedAssistant(ctx, client, config)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  thread := &assistantThread{client: client, assistantID: assistantID}
+  if err := thread.reset(ctx); err != nil {
+    return nil, err
+  }
+  return thread, nil
+}
+
+// assistantIDFilePath returns where the ID of the assistant created for
+// configs without assistant_id is kept: $XDG_CONFIG_HOME/llm-cli/assistant_id,
+// or ~/.config/llm-cli/assistant_id.
+func assistantIDFilePath() (string, error) {
+  dir, err := userConfigDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(dir, "assistant_id"), nil
+}
+
+// savedAssistant returns the assistant used when assistant_id is unset. The
+// one created by an earlier run is updated to the current model, name and
+// instructions and reused, so runs don't each leave a new assistant behind.
+// One is only created when none was saved or it has since been deleted.
+func savedAssistant(ctx context.Context, client *openai.Client, config Config) (string, error) {
+  name := config.AIName
+  instructions := systemPrompt(config)
+  request := openai.AssistantRequest{
+    Model:        config.Model,
+    Name:         &name,
+    Instructions: &instructions,
+  }
+
+  path, pathErr := assistantIDFilePath()
+  if pathErr == nil {
+    data, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+      return "", fmt.Errorf("reading saved assistant ID: %w", err)
+    }
+    if id := strings.TrimSpace(string(data)); id != "" {
+      _, err := client.ModifyAssistant(ctx, id, request)
+      if err == nil {
+        return id, nil
+      }
+      if httpStatusCode(err) != http.StatusNotFound {
+        return "", fmt.Errorf("updating assistant %s: %w", id, err)
+      }
+    }
+  }
+
+  assistant, err := client.CreateAssistant(ctx, request)
+  if err != nil {
+    return "", fmt.Errorf("creating assistant: %w", err)
+  }
+  if pathErr == nil {
+    pathErr = os.MkdirAll(filepath.Dir(path), 0o755)
+  }
+  if pathErr == nil {
+    _, pathErr = writeFileAtomic(path, assistant.ID+"\n", false)
+  }
+  if pathErr != nil {
+    slog.Warn("couldn't save the new assistant's ID; set assistant_id to reuse it", "id", assistant.ID, "err", pathErr)
+  } else {
+    slog.Info("created assistant", "id", assistant.ID, "saved", path)
+  }
+  return assistant.ID, nil
+}
+
+func (t *assistantThread) reset(ctx context.Context) error {
+  thread, err := t.client.CreateThread(ctx, openai.ThreadRequest{})
+  if err != nil {
+    return fmt.Errorf("creating thread: %w", err)
+  }
+  t.threadID = thread.ID
+  t.synced = nil
+  return nil
+}
+
+// complete pushes any unsent messages to the thread, runs the assistant and
+// returns its reply. If the local history no longer starts with what the
+// thread has seen (e.g. it was rewound or edited), a fresh thread is started
+// and the whole history is replayed.
+func (t *assistantThread) complete(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
+  if !t.inSync(messages) {
+    if err := t.reset(ctx); err != nil {
+      return "", err
+    }
+  }
+
+  for _, msg := range messages[len(t.synced):] {
+    // The system prompt lives on the assistant as its instructions.
+    if text := messageText(msg); msg.Role != openai.ChatMessageRoleSystem && text != "" {
+      _, err := t.client.CreateMessage(ctx, t.threadID, openai.MessageRequest{
+        Role:    msg.Role,
+        Content: text,
+      })
+      if err != nil {
+        return "", fmt.Errorf("adding message to thread: %w", err)
+      }
+    }
+    t.synced = append(t.synced, msg)
+  }
+
+  run, err := t.client.CreateRun(ctx, t.threadID, openai.RunRequest{AssistantID: t.assistantID})
+  if err != nil {
+    return "", fmt.Errorf("starting run: %w", err)
+  }
+
+  run, err = t.waitForRun(ctx, run)
+  if err != nil {
+    return "", err
+  }
+
+  response, err := t.runOutput(ctx, run.ID)
+  if err != nil {
+    return "", err
+  }
+  // The reply is already in the thread, so the caller appending it to the
+  // local history must not cause it to be sent again.
+  t.synced = append(t.synced, openai.ChatCompletionMessage{
+    Role:    openai.ChatMessageRoleAssistant,
+    Content: response,
+  })
+  return response, nil
+}
+
+func (t *assistantThread) inSync(messages []openai.ChatCompletionMessage) bool {
+  if len(messages) < len(t.synced) {
+    return false
+  }
+  for i, msg := range t.synced {
+    if !sameMessage(messages[i], msg) {
+      return false
+    }
+  }
+  return true
+}
+
+func (t *assistantThread) waitForRun(ctx context.Context, run openai.Run) (openai.Run, error) {
+  for {
+    switch run.Status {
+    case openai.RunStatusCompleted:
+      return run, nil
+    case openai.RunStatusRequiresAction:
+      _, _ = t.client.CancelRun(ctx, t.threadID, run.ID)
+      return run, fmt.Errorf("run %s requires tool outputs, which are not supported", run.ID)
+    case openai.RunStatusFailed:
+      if run.LastError != nil {
+        return run, fmt.Errorf("run failed: %s", run.LastError.Message)
+      }
+      return run, fmt.Errorf("run failed")
+    case openai.RunStatusCancelled, openai.RunStatusExpired, openai.RunStatusIncomplete:
+      return run, fmt.Errorf("run ended with status %s", run.Status)
+    }
+
+    select {
+    case <-ctx.Done():
+      return run, ctx.Err()
+    case <-time.After(runPollInterval):
+    }
+
+    var err error
+    run, err = t.client.RetrieveRun(ctx, t.threadID, run.ID)
+    if err != nil {
+      return run, fmt.Errorf("checking run: %w", err)
+    }
+  }
+}
+
+func (t *assistantThread) runOutput(ctx context.Context, runID string) (string, error) {
+  limit := 20
+  order := "desc"
+  list, err := t.client.ListMessage(ctx, t.threadID, &limit, &order, nil, nil)
+  if err != nil {
+    return "", fmt.Errorf("listing thread messages: %w", err)
+  }
+
+  var parts []string
+  for i := len(list.Messages) - 1; i >= 0; i-- {
+    msg := list.Messages[i]
+    if msg.RunID == nil || *msg.RunID != runID || msg.Role != openai.ChatMessageRoleAssistant {
+      continue
+    }
+    for _, content := range msg.Content {
+      if content.Text != nil {
+        parts = append(parts, content.Text.Value)
+      }
+    }
+  }
+  if len(parts) == 0 {
+    return "", fmt.Errorf("run %s produced no text output", runID)
+  }
+  return strings.Join(parts, "\n\n"), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+  "text/tabwriter"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// loadBatch reads the prompts of a -batch file, one per line. Blank lines are
+// skipped.
+func loadBatch(path string) ([]string, error) {
+  content, err := readFileOrStdin(path)
+  if err != nil {
+    return nil, fmt.Errorf("reading batch: %w", err)
+  }
+
+  var prompts []string
+  for _, line := range strings.Split(string(content), "\n") {
+    if prompt := strings.TrimSpace(line); prompt != "" {
+      prompts = append(prompts, prompt)
+    }
+  }
+  if len(prompts) == 0 {
+    return nil, fmt.Errorf("batch %s has no prompts", path)
+  }
+  return prompts, nil
+}
+
+func batchMessages(config Config, prompt string) []openai.ChatCompletionMessage {
+  return []openai.ChatCompletionMessage{
+    {Role: openai.ChatMessageRoleSystem, Content: systemPrompt(config)},
+    {Role: openai.ChatMessageRoleUser, Content: prompt},
+  }
+}
+
+// runBatch sends each prompt as its own conversation and prints the
+// responses in order.
+func runBatch(backend provider, config Config, prompts []string) error {
+  for i, prompt := range prompts {
+    if shouldSanitize(config) {
+      prompt = sanitizeInput(prompt)
+    }
+    prompt, err := runPreRequestHook(config, prompt)
+    if err != nil {
+      return fmt.Errorf("prompt %d: %w", i+1, err)
+    }
+    if err := moderateInput(context.Background(), backend, config, prompt); err != nil {
+      return fmt.Errorf("prompt %d: %w", i+1, err)
+    }
+
+    turnConfig := config
+    turnConfig.Model = routeTurn(config, prompt)
+    result, err := callModel(context.Background(), backend, turnConfig, batchMessages(config, prompt))
+    if err != nil {
+      return fmt.Errorf("prompt %d: %w", i+1, err)
+    }
+
+    fmt.Println(dimStyle.Render(fmt.Sprintf("[%d/%d] %s", i+1, len(prompts), truncateValue(prompt))))
+    if err := printFormattedResponse(result.content, answeredConfig(turnConfig, result)); err != nil {
+      return err
+    }
+    if config.ShowUsage {
+      fmt.Println(dimStyle.Render(formatUsage(result.usage, nil)))
+    }
+    fmt.Println()
+    runPostResponseHook(config, result.content, os.Stdout)
+  }
+  return nil
+}
+
+// printBatchEstimate writes the estimated tokens and cost of each prompt and
+// the total, without sending anything. Output is assumed to be outputRatio
+// times the input.
+func printBatchEstimate(w io.Writer, config Config, prompts []string, outputRatio float64) {
+  var totalInput, totalOutput int
+  var totalCost float64
+  priced := true
+
+  tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(tw, "#\tModel\tInput\tOutput\tCost\tPrompt")
+  for i, prompt := range prompts {
+    model := routeTurn(config, prompt)
+    input := estimateMessageTokens(batchMessages(config, prompt))
+    output := int(float64(input) * outputRatio)
+    totalInput += input
+    totalOutput += output
+
+    cost := "?"
+    if c, ok := estimateCost(model, input, output); ok {
+      cost = formatCost(c)
+      totalCost += c
+    } else {
+      priced = false
+    }
+    fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", i+1, model, formatTokenCount(input), formatTokenCount(output), cost, truncateValue(prompt))
+  }
+
+  total := formatCost(totalCost)
+  if !priced {
+    total += " + unpriced models"
+  }
+  fmt.Fprintf(tw, "total\t\t%s\t%s\t%s\t\n", formatTokenCount(totalInput), formatTokenCount(totalOutput), total)
+  tw.Flush()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+)
+
+// marshalCanonicalJSON encodes v with sorted object keys, two-space
+// indentation, no HTML escaping and a trailing newline, so files written
+// from the same data are byte-for-byte identical and diff cleanly.
+func marshalCanonicalJSON(v any) ([]byte, error) {
+  raw, err := json.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+
+  // Round-tripping through interface{} turns structs into maps, which
+  // encoding/json always writes in sorted key order. UseNumber keeps numeric
+  // values exactly as they were first encoded.
+  decoder := json.NewDecoder(bytes.NewReader(raw))
+  decoder.UseNumber()
+  var generic any
+  if err := decoder.Decode(&generic); err != nil {
+    return nil, err
+  }
+
+  var buf bytes.Buffer
+  encoder := json.NewEncoder(&buf)
+  encoder.SetEscapeHTML(false)
+  encoder.SetIndent("", "  ")
+  if err := encoder.Encode(generic); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
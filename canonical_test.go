@@ -0,0 +1,85 @@
+package main
+
+import (
+  "bytes"
+  "testing"
+)
+
+type canonicalSample struct {
+  Zeta    string            `json:"zeta"`
+  Alpha   int               `json:"alpha"`
+  Vars    map[string]string `json:"vars"`
+  Nested  map[string]any    `json:"nested"`
+  Content string            `json:"content"`
+}
+
+// canonicalSampleGolden is the expected encoding of newCanonicalSample: keys
+// sorted at every level, two-space indentation, no HTML escaping and a
+// trailing newline.
+const canonicalSampleGolden = `{
+  "alpha": 42,
+  "content": "<b>a & b</b>",
+  "nested": {
+    "b": true,
+    "list": [
+      3,
+      1,
+      2
+    ],
+    "m": 1.5
+  },
+  "vars": {
+    "apple": "1",
+    "mango": "3",
+    "zebra": "2"
+  },
+  "zeta": "last"
+}
+`
+
+// newCanonicalSample builds the same value with its vars map filled in the
+// order of keys, which must be a permutation of zebra, apple and mango.
+func newCanonicalSample(keys []string) canonicalSample {
+  values := map[string]string{"zebra": "2", "apple": "1", "mango": "3"}
+  vars := map[string]string{}
+  for _, key := range keys {
+    vars[key] = values[key]
+  }
+  nested := map[string]any{"m": 1.5, "b": true, "list": []int{3, 1, 2}}
+  return canonicalSample{Zeta: "last", Alpha: 42, Vars: vars, Nested: nested, Content: "<b>a & b</b>"}
+}
+
+func TestMarshalCanonicalJSONIsByteStable(t *testing.T) {
+  orders := [][]string{
+    {"zebra", "apple", "mango"},
+    {"apple", "mango", "zebra"},
+    {"mango", "zebra", "apple"},
+  }
+
+  var first []byte
+  for i, order := range orders {
+    for run := 0; run < 5; run++ {
+      out, err := marshalCanonicalJSON(newCanonicalSample(order))
+      if err != nil {
+        t.Fatalf("order %d: %v", i, err)
+      }
+      if first == nil {
+        first = out
+        continue
+      }
+      if !bytes.Equal(out, first) {
+        t.Fatalf("order %d, run %d: output differs:\n%s\nwant:\n%s", i, run, out, first)
+      }
+    }
+  }
+}
+
+func TestMarshalCanonicalJSONGolden(t *testing.T) {
+  out, err := marshalCanonicalJSON(newCanonicalSample([]string{"zebra", "apple", "mango"}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(out) != canonicalSampleGolden {
+    t.Errorf("got:\n%s\nwant:\n%s", out, canonicalSampleGolden)
+  }
+}
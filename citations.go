@@ -0,0 +1,85 @@
+package main
+
+import (
+  "regexp"
+  "strings"
+
+  "github.com/charmbracelet/lipgloss"
+)
+
+var (
+  // citationSource matches a sources-list entry such as "[1] https://..." or
+  // the link reference form "[1]: https://...".
+  citationSource = regexp.MustCompile(`^\s*\[(\d{1,3})\](:?)\s+(.+)$`)
+  citationURL    = regexp.MustCompile(`https?://[^\s<>)\]]+`)
+
+  // citationRef matches a reference in rendered output, where the renderer
+  // may have put SGR sequences between the brackets and the number.
+  citationRef = regexp.MustCompile(`\[((?:\x1b\[[0-9;]*m)*)(\d{1,3})((?:\x1b\[[0-9;]*m)*)\]`)
+  trailingSGR = regexp.MustCompile(`(?:\x1b\[[0-9;]*m)+$`)
+
+  citationStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("75")).Bold(true)
+)
+
+// citationSources finds the numbered sources in a response and rewrites
+// link reference definitions ("[1]: url"), which markdown renderers hide, as
+// plain "[1] url" lines so the list stays visible. It returns the rewritten
+// markdown and each source's URL, or "" where the source has none.
+func citationSources(markdown string) (string, map[string]string) {
+  sources := map[string]string{}
+  lines := strings.Split(markdown, "\n")
+  for i, line := range lines {
+    m := citationSource.FindStringSubmatch(line)
+    if m == nil {
+      continue
+    }
+    sources[m[1]] = citationURL.FindString(m[3])
+    if m[2] == ":" {
+      // A blank line keeps consecutive entries from merging into one
+      // paragraph.
+      lines[i] = "[" + m[1] + "] " + m[3] + "\n"
+    }
+  }
+  return strings.Join(lines, "\n"), sources
+}
+
+// styleCitations highlights the references in rendered output that have a
+// matching source, linking them with OSC 8 hyperlinks when the source is a
+// URL. Numbers without a source, and index-like uses such as arr[1], are
+// left alone.
+func styleCitations(rendered string, sources map[string]string) string {
+  var out strings.Builder
+  last := 0
+  for _, m := range citationRef.FindAllStringSubmatchIndex(rendered, -1) {
+    start, end := m[0], m[1]
+    number := rendered[m[4]:m[5]]
+    url, ok := sources[number]
+    if !ok || followsWord(rendered[:start]) {
+      continue
+    }
+
+    styled := citationStyle.Render("[" + number + "]")
+    if url != "" {
+      styled = "\x1b]8;;" + url + "\x1b\\" + styled + "\x1b]8;;\x1b\\"
+    }
+    out.WriteString(rendered[last:start])
+    out.WriteString(styled)
+    // Replay the renderer's own sequences so the text after the reference
+    // keeps its style.
+    out.WriteString(rendered[m[2]:m[3]] + rendered[m[6]:m[7]])
+    last = end
+  }
+  out.WriteString(rendered[last:])
+  return out.String()
+}
+
+// followsWord reports whether the visible text before a match ends in a
+// letter, digit or underscore.
+func followsWord(before string) bool {
+  before = trailingSGR.ReplaceAllString(before, "")
+  if before == "" {
+    return false
+  }
+  c := before[len(before)-1]
+  return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+  "errors"
+  "os"
+  "os/exec"
+  "runtime"
+)
+
+// clipboardReaders lists, per platform, the commands tried in order to read
+// the system clipboard.
+var clipboardReaders = map[string][][]string{
+  "darwin":  {{"pbpaste"}},
+  "windows": {{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"}},
+  "linux": {
+    {"wl-paste", "--no-newline"},
+    {"xclip", "-selection", "clipboard", "-o"},
+    {"xsel", "--clipboard", "--output"},
+  },
+}
+
+func readClipboard() (string, error) {
+  candidates := clipboardReaders[runtime.GOOS]
+  // Prefer wl-paste only under Wayland; under X11 it fails even if installed.
+  if runtime.GOOS == "linux" && os.Getenv("WAYLAND_DISPLAY") == "" {
+    candidates = candidates[1:]
+  }
+
+  for _, candidate := range candidates {
+    if _, err := exec.LookPath(candidate[0]); err != nil {
+      continue
+    }
+    out, err := exec.Command(candidate[0], candidate[1:]...).Output()
+    if err != nil {
+      return "", err
+    }
+    return string(out), nil
+  }
+  return "", errors.New("no clipboard tool found (install pbpaste, wl-paste, xclip or xsel)")
+}
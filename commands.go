@@ -0,0 +1,78 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+
+  "github.com/charmbracelet/lipgloss"
+)
+
+type replCommand struct {
+  name        string
+  args        string
+  description string
+}
+
+// replCommands lists every interactive command for :help and Tab
+// completion. A new command needs an entry here as well as its handler.
+var replCommands = []replCommand{
+  {cmdHelp, "", "Show this list"},
+  {cmdQuit, "", "Exit interactive mode"},
+  {cmdMulti, "", "Enter or leave multiline mode"},
+  {cmdEnd, "", "Send the message in multiline mode"},
+  {cmdRemove, "", "Delete the last line in multiline mode"},
+  {cmdClear, "", "Start the conversation over, keeping the system prompt"},
+  {strings.TrimSpace(cmdFile), "<path|pattern|dir>", "Add files to the context"},
+  {strings.TrimSpace(cmdURL), "<url>", "Add the text of a web page to the context"},
+  {cmdFiles, "", "List the files in context"},
+  {strings.TrimSpace(cmdImage), "<path>", "Attach an image to your next message"},
+  {cmdRemoveFile, "<name>", "Remove a file from the context"},
+  {cmdGitDiff, "[args]", "Add the output of git diff to the context"},
+  {cmdModel, "[name]", "Show or switch the model"},
+  {cmdSystem, "[text]", "Show or replace the system prompt"},
+  {cmdPersona, "<name>", "Switch to a persona's system prompt"},
+  {cmdPersonas, "", "List the available personas"},
+  {cmdProfile, "[name]", "List the profiles or switch to one"},
+  {cmdTemp, "[value]", "Show or set the temperature"},
+  {cmdMaxTokens, "[<n>|off]", "Show or set the response token limit"},
+  {cmdStream, "", "Toggle streaming"},
+  {cmdWrap, "[<n>|auto] [-render]", "Show or set the wrap width"},
+  {cmdEdit, "", "Edit your last message in $EDITOR and send it again"},
+  {cmdUndo, "", "Remove the last message and its response"},
+  {cmdRegenerate, "", "Replace the last response with a new one"},
+  {cmdRegenTemp, "<temperature> [-diff]", "Regenerate the last response at another temperature"},
+  {cmdRaw, "", "Print the last response without rendering"},
+  {cmdScratch, "", "Edit the last response in $EDITOR"},
+  {cmdScratchSend, "", "Send the edited scratch buffer"},
+  {cmdTruncateTo, "<index>", "Drop the messages after index"},
+  {cmdDump, "", "Print the conversation as JSON"},
+  {cmdPreview, "", "Show what the next message will send and ask before sending"},
+  {cmdConfigDiff, "", "Show settings that differ from the defaults"},
+  {cmdWhy, "", "Explain why the last turn failed"},
+  {cmdSet, "<name> = <instruction>", "Extract a value from the conversation into ${name}"},
+  {cmdCapture, "<name>", "Save the last response as ${name}"},
+  {cmdVars, "", "List variables"},
+  {cmdSave, "[file]", "Save the conversation"},
+  {cmdLoad, "<file>", "Load a saved conversation"},
+}
+
+// formatHelp renders replCommands as a two-column table.
+func formatHelp() string {
+  commandStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("86"))
+
+  usages := make([]string, len(replCommands))
+  width := 0
+  for i, c := range replCommands {
+    usages[i] = strings.TrimSpace(c.name + " " + c.args)
+    width = max(width, len(usages[i]))
+  }
+
+  var out strings.Builder
+  for i, c := range replCommands {
+    if i > 0 {
+      out.WriteByte('\n')
+    }
+    fmt.Fprintf(&out, "  %s  %s", commandStyle.Render(fmt.Sprintf("%-*s", width, usages[i])), c.description)
+  }
+  return out.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "slices"
+  "strings"
+)
+
+// pathCommands take a file path, which Tab completes after the command.
+var pathCommands = []string{strings.TrimSpace(cmdFile), strings.TrimSpace(cmdImage), cmdSave, cmdLoad}
+
+// completeInput is the REPL's completer. A word starting with ":" at the
+// start of the line completes to a command, matched case-insensitively like
+// the commands themselves; the argument of a command in pathCommands
+// completes to a path relative to the current directory.
+func completeInput(before []rune) (start int, candidates []string, ok bool) {
+  text := string(before)
+  command, arg, hasArg := strings.Cut(text, " ")
+  if !strings.HasPrefix(command, ":") {
+    return 0, nil, false
+  }
+  if !hasArg {
+    prefix := strings.ToLower(command)
+    for _, c := range replCommands {
+      if strings.HasPrefix(c.name, prefix) {
+        candidates = append(candidates, c.name)
+      }
+    }
+    slices.Sort(candidates)
+    return 0, candidates, true
+  }
+  if !slices.Contains(pathCommands, strings.ToLower(command)) {
+    return 0, nil, false
+  }
+  return len(before) - len([]rune(arg)), completePath(arg), true
+}
+
+// completePath lists the entries of partial's directory whose names start
+// with its last element. Directories end in a slash so completion can carry
+// on into them, and hidden entries are only offered once a "." is typed.
+func completePath(partial string) []string {
+  dir, base := filepath.Split(partial)
+  readDir := dir
+  if readDir == "" {
+    readDir = "."
+  }
+  entries, err := os.ReadDir(readDir)
+  if err != nil {
+    return nil
+  }
+
+  var matches []string
+  for _, entry := range entries {
+    name := entry.Name()
+    if !strings.HasPrefix(name, base) || strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+      continue
+    }
+    match := dir + name
+    if entry.IsDir() {
+      match += string(filepath.Separator)
+    }
+    matches = append(matches, match)
+  }
+  return matches
+}
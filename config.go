@@ -0,0 +1,260 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sort"
+  "strings"
+  "text/tabwriter"
+
+  "github.com/charmbracelet/lipgloss"
+)
+
+// defaultConfig returns the built-in defaults, matching the config.json
+// shipped with the repository. Fields left at their zero value fall back to
+// the behaviour documented on the field.
+func defaultConfig() Config {
+  return Config{
+    Model:        "gpt-4o-mini",
+    AIName:       "Li",
+    SystemPrompt: "You are an exceptional and concise coding assistant.",
+    Style:        "tokyo_night",
+  }
+}
+
+// userConfigDir returns the per-user llm-cli directory:
+// $XDG_CONFIG_HOME/llm-cli, or ~/.config/llm-cli when that is unset.
+func userConfigDir() (string, error) {
+  if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+    return filepath.Join(xdg, "llm-cli"), nil
+  }
+  home, err := os.UserHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, ".config", "llm-cli"), nil
+}
+
+// configSearchDirs lists where config.json and the styles folder are looked
+// up, in order: $XDG_CONFIG_HOME/llm-cli, ~/.config/llm-cli, then the
+// working directory.
+func configSearchDirs() []string {
+  var dirs []string
+  if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+    dirs = append(dirs, filepath.Join(xdg, "llm-cli"))
+  }
+  if home, err := os.UserHomeDir(); err == nil {
+    dirs = append(dirs, filepath.Join(home, ".config", "llm-cli"))
+  }
+  return append(dirs, ".")
+}
+
+// findConfigFile returns the first existing file at rel under the search
+// directories. When there is none it returns an error naming every path
+// tried.
+func findConfigFile(rel string) (string, error) {
+  var tried []string
+  for _, dir := range configSearchDirs() {
+    path := filepath.Join(dir, rel)
+    if _, err := os.Stat(path); err == nil {
+      return path, nil
+    }
+    tried = append(tried, path)
+  }
+  return "", fmt.Errorf("%s not found (tried %s)", rel, strings.Join(tried, ", "))
+}
+
+// configValues flattens a config into its JSON keys and encoded values.
+// Keys omitted by omitempty are absent, meaning "use the default".
+func configValues(config Config) (map[string]string, error) {
+  raw, err := json.Marshal(config)
+  if err != nil {
+    return nil, err
+  }
+  var fields map[string]json.RawMessage
+  if err := json.Unmarshal(raw, &fields); err != nil {
+    return nil, err
+  }
+
+  values := make(map[string]string, len(fields))
+  for key, value := range fields {
+    values[key] = string(value)
+  }
+  return values, nil
+}
+
+// formatConfigDiff renders the settings where config differs from the
+// built-in defaults as a two-column table.
+func formatConfigDiff(config Config) (string, error) {
+  defaults, err := configValues(defaultConfig())
+  if err != nil {
+    return "", err
+  }
+  current, err := configValues(config)
+  if err != nil {
+    return "", err
+  }
+
+  keys := map[string]bool{}
+  for key := range defaults {
+    keys[key] = true
+  }
+  for key := range current {
+    keys[key] = true
+  }
+  sorted := make([]string, 0, len(keys))
+  for key := range keys {
+    sorted = append(sorted, key)
+  }
+  sort.Strings(sorted)
+
+  lookup := func(values map[string]string, key string) string {
+    if value, ok := values[key]; ok {
+      return value
+    }
+    return "(default)"
+  }
+
+  changedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("204"))
+
+  var out strings.Builder
+  tw := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(tw, "setting\tdefault\tcurrent")
+  changed := 0
+  for _, key := range sorted {
+    def, cur := lookup(defaults, key), lookup(current, key)
+    if def == cur {
+      continue
+    }
+    changed++
+    fmt.Fprintf(tw, "%s\t%s\t%s\n", key, truncateValue(def), changedStyle.Render(truncateValue(cur)))
+  }
+  tw.Flush()
+
+  if changed == 0 {
+    return "Config matches the built-in defaults.", nil
+  }
+  return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+// truncateValue keeps long values such as system prompts to one short cell.
+func truncateValue(value string) string {
+  const maxLen = 40
+  value = strings.ReplaceAll(value, "\n", " ")
+  if runes := []rune(value); len(runes) > maxLen {
+    return string(runes[:maxLen-3]) + "..."
+  }
+  return value
+}
+
+// validateConfig checks the settings every run needs, so a missing one is
+// reported when the config is loaded rather than on the first request. An
+// empty system prompt is allowed.
+func validateConfig(config Config) error {
+  if config.Model == "" {
+    return errors.New(`"model" is not set; add e.g. "model": "gpt-4o-mini"`)
+  }
+  if config.Style == "" {
+    return errors.New(`"style" is not set; add e.g. "style": "tokyo_night", naming a built-in style or a file in the styles folder`)
+  }
+  if config.TimeoutSeconds < 0 {
+    return fmt.Errorf(`"timeout_seconds" must not be negative, got %d`, config.TimeoutSeconds)
+  }
+  if config.ResponseFormat != "" {
+    if err := checkResponseFormat(config.ResponseFormat); err != nil {
+      return fmt.Errorf(`"response_format": %w`, err)
+    }
+  }
+  if err := checkRoutingRules(config.ModelRouting); err != nil {
+    return fmt.Errorf(`"model_routing": %w`, err)
+  }
+  if config.PresencePenalty < -2 || config.PresencePenalty > 2 {
+    return fmt.Errorf(`"presence_penalty" must be between -2 and 2, got %g`, config.PresencePenalty)
+  }
+  if config.FrequencyPenalty < -2 || config.FrequencyPenalty > 2 {
+    return fmt.Errorf(`"frequency_penalty" must be between -2 and 2, got %g`, config.FrequencyPenalty)
+  }
+  if isBuiltinStyle(config.Style) {
+    return nil
+  }
+  if _, err := findConfigFile(filepath.Join("styles", config.Style+".json")); err != nil {
+    return fmt.Errorf("style %q is not built in: %w", config.Style, err)
+  }
+  return nil
+}
+
+// describeDecodeError turns the decoder's errors for unknown keys and
+// mistyped values into messages naming the setting at fault.
+func describeDecodeError(err error) error {
+  if key, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+    key = strings.Trim(key, `"`)
+    if suggestion := closestConfigKey(key); suggestion != "" {
+      return fmt.Errorf("unknown setting %q (did you mean %q?)", key, suggestion)
+    }
+    return fmt.Errorf("unknown setting %q", key)
+  }
+  var typeErr *json.UnmarshalTypeError
+  if errors.As(err, &typeErr) && typeErr.Field != "" {
+    return fmt.Errorf("%q should be %s, not a %s", typeErr.Field, describeJSONType(typeErr.Type), typeErr.Value)
+  }
+  return err
+}
+
+func describeJSONType(t reflect.Type) string {
+  if t.Kind() == reflect.Pointer {
+    t = t.Elem()
+  }
+  switch t.Kind() {
+  case reflect.String:
+    return "a string"
+  case reflect.Bool:
+    return "true or false"
+  case reflect.Int, reflect.Int64, reflect.Float32, reflect.Float64:
+    return "a number"
+  case reflect.Slice:
+    return "a list"
+  case reflect.Map, reflect.Struct:
+    return "an object"
+  }
+  return t.String()
+}
+
+// closestConfigKey returns the Config key within two edits of key, if any.
+func closestConfigKey(key string) string {
+  best, bestDistance := "", 3
+  configType := reflect.TypeOf(Config{})
+  for i := 0; i < configType.NumField(); i++ {
+    name, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+    if name == "" || name == "-" {
+      continue
+    }
+    if d := editDistance(strings.ToLower(key), name); d < bestDistance {
+      best, bestDistance = name, d
+    }
+  }
+  return best
+}
+
+func editDistance(a, b string) int {
+  previous := make([]int, len(b)+1)
+  for j := range previous {
+    previous[j] = j
+  }
+  for i := 1; i <= len(a); i++ {
+    current := make([]int, len(b)+1)
+    current[0] = i
+    for j := 1; j <= len(b); j++ {
+      cost := 1
+      if a[i-1] == b[j-1] {
+        cost = 0
+      }
+      current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+    }
+    previous = current
+  }
+  return previous[len(b)]
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+  "fmt"
+  "slices"
+  "strings"
+)
+
+// diffContextLines is how many unchanged lines surround each hunk.
+const diffContextLines = 3
+
+// maxDiffEdits bounds how many lines diffLines lets two texts differ by.
+// Its search keeps a trace that grows with the square of that distance,
+// about 8 MB at this bound; texts further apart are diffed as a single
+// replace-everything hunk.
+const maxDiffEdits = 1000
+
+type diffOp struct {
+  kind byte // ' ', '-' or '+'
+  line string
+}
+
+// unifiedDiff returns a unified diff between oldText and newText, or an
+// empty string if they are identical.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+  if oldText == newText {
+    return ""
+  }
+
+  ops := diffLines(splitLines(oldText), splitLines(newText))
+
+  var out strings.Builder
+  fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+  // Walk the edit script, emitting a hunk for each run of changes plus
+  // surrounding context. Hunks closer than twice the context are merged.
+  oldLine, newLine := 1, 1
+  for i := 0; i < len(ops); {
+    if ops[i].kind == ' ' {
+      i++
+      oldLine++
+      newLine++
+      continue
+    }
+
+    start := i
+    for start > 0 && i-start < diffContextLines && ops[start-1].kind == ' ' {
+      start--
+    }
+    hunkOld := oldLine - (i - start)
+    hunkNew := newLine - (i - start)
+
+    end := i
+    for end < len(ops) {
+      if ops[end].kind != ' ' {
+        end++
+        continue
+      }
+      run := end
+      for run < len(ops) && ops[run].kind == ' ' {
+        run++
+      }
+      if run == len(ops) || run-end > 2*diffContextLines {
+        end += min(run-end, diffContextLines)
+        break
+      }
+      end = run
+    }
+
+    oldCount, newCount := 0, 0
+    var body strings.Builder
+    for _, op := range ops[start:end] {
+      body.WriteByte(op.kind)
+      body.WriteString(op.line)
+      body.WriteByte('\n')
+      if op.kind != '+' {
+        oldCount++
+      }
+      if op.kind != '-' {
+        newCount++
+      }
+    }
+
+    fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(hunkOld, oldCount), hunkRange(hunkNew, newCount))
+    out.WriteString(body.String())
+
+    for _, op := range ops[i:end] {
+      if op.kind != '+' {
+        oldLine++
+      }
+      if op.kind != '-' {
+        newLine++
+      }
+    }
+    i = end
+  }
+
+  return out.String()
+}
+
+func hunkRange(start, count int) string {
+  if count == 0 {
+    return fmt.Sprintf("%d,0", start-1)
+  }
+  if count == 1 {
+    return fmt.Sprintf("%d", start)
+  }
+  return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(text string) []string {
+  if text == "" {
+    return nil
+  }
+  return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a shortest line edit script with Myers' O(ND)
+// algorithm, which stays fast when the texts are long but mostly alike.
+func diffLines(a, b []string) []diffOp {
+  n, m := len(a), len(b)
+  // v[k] is the furthest x reached on diagonal k = x - y, offset so that
+  // k = -d-1 ... d+1 all fit. trace[d] is a copy of v[-d-1 ... d+1] before
+  // step d, for walking the path back.
+  offset := min(n+m, maxDiffEdits) + 1
+  v := make([]int, 2*offset+1)
+  var trace [][]int
+  found := false
+  for d := 0; d <= n+m && d <= maxDiffEdits && !found; d++ {
+    trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+    for k := -d; k <= d; k += 2 {
+      var x int
+      if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+        x = v[offset+k+1]
+      } else {
+        x = v[offset+k-1] + 1
+      }
+      y := x - k
+      for x < n && y < m && a[x] == b[y] {
+        x++
+        y++
+      }
+      v[offset+k] = x
+      if x >= n && y >= m {
+        found = true
+        break
+      }
+    }
+  }
+  if !found {
+    ops := make([]diffOp, 0, n+m)
+    for _, line := range a {
+      ops = append(ops, diffOp{'-', line})
+    }
+    for _, line := range b {
+      ops = append(ops, diffOp{'+', line})
+    }
+    return ops
+  }
+
+  var ops []diffOp
+  x, y := n, m
+  for d := len(trace) - 1; d >= 0; d-- {
+    prev := func(k int) int { return trace[d][k+d+1] }
+    k := x - y
+    prevK := k - 1
+    if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+      prevK = k + 1
+    }
+    prevX := prev(prevK)
+    prevY := prevX - prevK
+    for x > prevX && y > prevY {
+      ops = append(ops, diffOp{' ', a[x-1]})
+      x--
+      y--
+    }
+    if d > 0 {
+      if x == prevX {
+        ops = append(ops, diffOp{'+', b[y-1]})
+      } else {
+        ops = append(ops, diffOp{'-', a[x-1]})
+      }
+    }
+    x, y = prevX, prevY
+  }
+  slices.Reverse(ops)
+  return ops
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "strings"
+)
+
+// editInEditor opens initial in $EDITOR (vi if unset) and returns the saved
+// contents. The temporary file is removed afterwards.
+func editInEditor(initial string) (string, error) {
+  file, err := os.CreateTemp("", "llm-cli-*.md")
+  if err != nil {
+    return "", err
+  }
+  defer os.Remove(file.Name())
+
+  if _, err := file.WriteString(initial); err != nil {
+    file.Close()
+    return "", err
+  }
+  if err := file.Close(); err != nil {
+    return "", err
+  }
+
+  editor := strings.Fields(os.Getenv("EDITOR"))
+  if len(editor) == 0 {
+    editor = []string{"vi"}
+  }
+
+  cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+  cmd.Stdin = os.Stdin
+  cmd.Stdout = os.Stdout
+  cmd.Stderr = os.Stderr
+  if err := cmd.Run(); err != nil {
+    return "", fmt.Errorf("running %s: %w", editor[0], err)
+  }
+
+  content, err := os.ReadFile(file.Name())
+  if err != nil {
+    return "", err
+  }
+  return string(content), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// embedCommand is the subcommand that prints an embedding instead of
+// chatting: llm-cli embed -p "some text".
+const embedCommand = "embed"
+
+// defaultEmbeddingModel is used when Config.EmbeddingModel is unset.
+const defaultEmbeddingModel = "text-embedding-3-small"
+
+// runEmbed prints the embedding of text as a JSON array of floats, on one
+// line, for feeding into a vector store.
+func runEmbed(ctx context.Context, backend provider, config Config, text string) error {
+  client := openAIClient(backend)
+  if client == nil {
+    return fmt.Errorf("embeddings need the %s provider", providerOpenAI)
+  }
+  if text == "" {
+    return errors.New("no text to embed; pass -p or pipe it on stdin")
+  }
+  model := config.EmbeddingModel
+  if model == "" {
+    model = defaultEmbeddingModel
+  }
+
+  var resp openai.EmbeddingResponse
+  err := withRetry(ctx, config, func() error {
+    requestCtx, cancel := withRequestTimeout(ctx, config)
+    defer cancel()
+    var err error
+    resp, err = client.CreateEmbeddings(requestCtx, openai.EmbeddingRequestStrings{
+      Input: []string{text},
+      Model: openai.EmbeddingModel(model),
+    })
+    return describeTimeout(ctx, config, err)
+  })
+  if err != nil {
+    return err
+  }
+  if len(resp.Data) == 0 {
+    return errors.New("the API returned no embedding")
+  }
+
+  out, err := json.Marshal(resp.Data[0].Embedding)
+  if err != nil {
+    return err
+  }
+  _, err = fmt.Fprintln(os.Stdout, string(out))
+  return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// explainErrorPrompt is the system prompt for :why.
+const explainErrorPrompt = "You help users of a command-line chat client troubleshoot API errors. Explain in plain language what the error below means and suggest concrete fixes, such as a config change, a different model or checking the API key. Be brief."
+
+// turnError records a failed turn for :why.
+type turnError struct {
+  err   error
+  model string
+}
+
+// explainError asks the model to explain a failed turn. It is a standalone
+// request: the conversation history is neither sent nor modified.
+func explainError(ctx context.Context, backend provider, config Config, failed turnError) (string, error) {
+  var details strings.Builder
+  fmt.Fprintf(&details, "Error: %v\n", failed.err)
+  fmt.Fprintf(&details, "Model: %s\n", failed.model)
+  fmt.Fprintf(&details, "Mode: %s\n", config.Mode)
+  if status := httpStatusCode(failed.err); status != 0 {
+    fmt.Fprintf(&details, "HTTP status: %d\n", status)
+  }
+  var apiErr *openai.APIError
+  if errors.As(failed.err, &apiErr) {
+    fmt.Fprintf(&details, "Error type: %s\n", apiErr.Type)
+    if apiErr.Code != nil {
+      fmt.Fprintf(&details, "Error code: %v\n", apiErr.Code)
+    }
+  }
+  if len(config.FallbackModels) > 0 {
+    fmt.Fprintf(&details, "Fallback models: %s\n", strings.Join(config.FallbackModels, ", "))
+  }
+
+  // The explanation is free text, so the response schema and token limit
+  // configured for normal turns don't apply.
+  explainConfig := config
+  explainConfig.responseSchema = nil
+  explainConfig.MaxTokens = 0
+
+  result, err := requestCompletion(ctx, backend, explainConfig, []openai.ChatCompletionMessage{
+    {Role: openai.ChatMessageRoleSystem, Content: explainErrorPrompt},
+    {Role: openai.ChatMessageRoleUser, Content: details.String()},
+  })
+  return result.content, err
+}
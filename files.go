@@ -0,0 +1,187 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io/fs"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "slices"
+  "strings"
+  "unicode/utf8"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// Size limits for :file, so a pattern or directory can't fill the context
+// window by accident.
+const (
+  maxContextFileBytes  = 256 << 10
+  maxContextTotalBytes = 1 << 20
+)
+
+// binarySniffBytes is how much of a file is checked for NUL bytes.
+const binarySniffBytes = 8000
+
+type contextFile struct {
+  name    string
+  content string
+}
+
+// loadContextFiles reads the files named by a :file argument: one path, a
+// glob pattern such as src/*.go, or a directory, which is walked with hidden
+// files and directories left out. Binary and oversized files matched by a
+// pattern or directory are skipped with a warning; a single path that is
+// one of those is an error. Nothing is returned if the files together are
+// over maxContextTotalBytes.
+func loadContextFiles(arg string) ([]contextFile, error) {
+  paths, expanded, err := contextFilePaths(arg)
+  if err != nil {
+    return nil, err
+  }
+  if !expanded {
+    content, err := readContextFile(arg)
+    if err != nil {
+      return nil, err
+    }
+    return []contextFile{{name: arg, content: content}}, nil
+  }
+
+  var files []contextFile
+  total := 0
+  for _, path := range paths {
+    content, err := readContextFile(path)
+    if err != nil {
+      slog.Warn("skipping file", "err", err)
+      continue
+    }
+    total += len(content)
+    if total > maxContextTotalBytes {
+      return nil, fmt.Errorf("%s matches more than %s of files; narrow it down", arg, formatByteSize(maxContextTotalBytes))
+    }
+    files = append(files, contextFile{name: path, content: content})
+  }
+  if len(files) == 0 {
+    return nil, fmt.Errorf("no text files found for %s", arg)
+  }
+  return files, nil
+}
+
+// contextFilePaths expands a glob pattern or directory into the regular
+// files it covers, sorted. expanded is false for a plain file path.
+func contextFilePaths(arg string) (paths []string, expanded bool, err error) {
+  if strings.ContainsAny(arg, "*?[") {
+    matches, err := filepath.Glob(arg)
+    if err != nil {
+      return nil, false, fmt.Errorf("invalid pattern %q: %w", arg, err)
+    }
+    for _, match := range matches {
+      if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+        paths = append(paths, match)
+      }
+    }
+    if len(paths) == 0 {
+      return nil, false, fmt.Errorf("no files match %s", arg)
+    }
+    return paths, true, nil
+  }
+
+  info, err := os.Stat(arg)
+  if err != nil || !info.IsDir() {
+    // Let the read report a missing file.
+    return nil, false, nil
+  }
+  err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+    if err != nil {
+      return err
+    }
+    if path != arg && strings.HasPrefix(d.Name(), ".") {
+      if d.IsDir() {
+        return filepath.SkipDir
+      }
+      return nil
+    }
+    if d.Type().IsRegular() {
+      paths = append(paths, path)
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, false, err
+  }
+  slices.Sort(paths)
+  return paths, true, nil
+}
+
+// readContextFile reads a text file for :file, refusing ones over
+// maxContextFileBytes.
+func readContextFile(path string) (string, error) {
+  info, err := os.Stat(path)
+  if err != nil {
+    return "", err
+  }
+  if info.Size() > maxContextFileBytes {
+    return "", fmt.Errorf("%s is %s, over the %s limit for one file", path, formatByteSize(int(info.Size())), formatByteSize(maxContextFileBytes))
+  }
+  return readFile(path)
+}
+
+// looksBinary reports whether data has a NUL byte near the start or isn't
+// valid UTF-8.
+func looksBinary(data []byte) bool {
+  return bytes.IndexByte(data[:min(len(data), binarySniffBytes)], 0) >= 0 || !utf8.Valid(data)
+}
+
+// The context messages :file adds for a path. Each starts with a label
+// naming the file, which is how removeFileContext finds them again.
+func fileContentMessage(name, content string) string {
+  return fmt.Sprintf("Content of %s:\n%s", name, content)
+}
+
+func fileUpdateMessage(name, content string) string {
+  return fmt.Sprintf("Updated content of %s:\n%s", name, content)
+}
+
+func fileDiffMessage(name, diff string) string {
+  return fmt.Sprintf("Changes to %s since it was last added:\n```diff\n%s```", name, diff)
+}
+
+func isFileContextMessage(msg openai.ChatCompletionMessage, name string) bool {
+  if msg.Role != openai.ChatMessageRoleUser {
+    return false
+  }
+  for _, label := range []string{
+    "Content of " + name + ":\n",
+    "Updated content of " + name + ":\n",
+    "Changes to " + name + " since it was last added:\n",
+  } {
+    if strings.HasPrefix(messageText(msg), label) {
+      return true
+    }
+  }
+  return false
+}
+
+// removeFileContext drops every context message added for name.
+func removeFileContext(messages []openai.ChatCompletionMessage, name string) []openai.ChatCompletionMessage {
+  return slices.DeleteFunc(messages, func(msg openai.ChatCompletionMessage) bool {
+    return isFileContextMessage(msg, name)
+  })
+}
+
+// formatContextFiles lists the files in context with the size last sent for
+// each.
+func formatContextFiles(files []string, versions map[string]string) string {
+  if len(files) == 0 {
+    return "No files in context."
+  }
+  var out strings.Builder
+  for i, name := range files {
+    if i > 0 {
+      out.WriteByte('\n')
+    }
+    fmt.Fprintf(&out, "  %s %s", name, dimStyle.Render(formatByteSize(len(versions[name]))))
+  }
+  return out.String()
+}
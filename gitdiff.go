@@ -0,0 +1,78 @@
+package main
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "os/exec"
+  "strconv"
+  "strings"
+)
+
+// gitDiff runs "git diff" with args (e.g. "--staged" or "HEAD~3") in the
+// current directory and returns its output.
+func gitDiff(args []string) (string, error) {
+  if _, err := exec.LookPath("git"); err != nil {
+    return "", errors.New("git is not installed")
+  }
+  out, err := exec.Command("git", "rev-parse", "--is-inside-work-tree").Output()
+  if err != nil || strings.TrimSpace(string(out)) != "true" {
+    return "", errors.New("not inside a git repository")
+  }
+
+  var stdout, stderr bytes.Buffer
+  cmd := exec.Command("git", append([]string{"diff", "--no-color", "--no-ext-diff"}, args...)...)
+  cmd.Stdout = &stdout
+  cmd.Stderr = &stderr
+  if err := cmd.Run(); err != nil {
+    return "", fmt.Errorf("git diff failed: %s", strings.TrimSpace(stderr.String()))
+  }
+  if stdout.Len() == 0 {
+    return "", fmt.Errorf("%s shows no changes", gitDiffLabel(args))
+  }
+  return stdout.String(), nil
+}
+
+func gitDiffLabel(args []string) string {
+  return strings.TrimSpace("git diff " + strings.Join(args, " "))
+}
+
+// gitDiffContext wraps diff output as a context message for the model.
+func gitDiffContext(args []string, diff string) string {
+  return fmt.Sprintf("Output of `%s`:\n```diff\n%s```", gitDiffLabel(args), diff)
+}
+
+// gitDiffFlag backs -gitdiff, a boolean flag, and -gitdiff-args, which sets
+// what to diff (e.g. -gitdiff-args=HEAD~3 or -gitdiff-args=--staged) and
+// turns -gitdiff on.
+type gitDiffFlag struct {
+  enabled bool
+  args    []string
+}
+
+func (f *gitDiffFlag) String() string {
+  if f == nil {
+    return "false"
+  }
+  return strconv.FormatBool(f.enabled)
+}
+
+func (f *gitDiffFlag) Set(value string) error {
+  enabled, err := strconv.ParseBool(value)
+  if err != nil {
+    return fmt.Errorf("want true or false; choose what to diff with -gitdiff-args=%s", value)
+  }
+  f.enabled = enabled
+  return nil
+}
+
+// setArgs handles -gitdiff-args.
+func (f *gitDiffFlag) setArgs(value string) error {
+  f.enabled = true
+  f.args = strings.Fields(value)
+  return nil
+}
+
+func (f *gitDiffFlag) IsBoolFlag() bool {
+  return true
+}
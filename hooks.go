@@ -0,0 +1,86 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+  "os/exec"
+  "strings"
+)
+
+// Hook names accepted in the config "hooks" map.
+//
+// Hooks are shell commands run with "sh -c" and therefore execute arbitrary
+// code with the user's permissions. The contract is:
+//
+//   pre_request   stdin: the user message. On exit 0, stdout replaces the
+//                 message, less one trailing newline (an empty stdout
+//                 leaves it unchanged). A non-zero exit cancels the request
+//                 and its stderr is shown.
+//   post_response stdin: the model's response. Its stdout and stderr go to
+//                 the terminal. A non-zero exit is reported but the response
+//                 is kept.
+//
+// Both hooks see LLM_CLI_HOOK and LLM_CLI_MODEL in their environment.
+const (
+  hookPreRequest =   "pre_request"
+  hookPostResponse = "post_response"
+)
+
+func runPreRequestHook(config Config, content string) (string, error) {
+  script := config.Hooks[hookPreRequest]
+  if script == "" {
+    return content, nil
+  }
+
+  var stdout, stderr bytes.Buffer
+  cmd := hookCommand(script, hookPreRequest, config)
+  cmd.Stdin = strings.NewReader(content)
+  cmd.Stdout = &stdout
+  cmd.Stderr = &stderr
+  if err := cmd.Run(); err != nil {
+    return "", fmt.Errorf("%s hook failed: %v: %s", hookPreRequest, err, strings.TrimSpace(stderr.String()))
+  }
+
+  // Commands like sed and echo end their output with a newline the user
+  // didn't type, so one is dropped.
+  out := stdout.String()
+  if strings.HasSuffix(out, "\r\n") {
+    out = strings.TrimSuffix(out, "\r\n")
+  } else {
+    out = strings.TrimSuffix(out, "\n")
+  }
+  if out == "" {
+    return content, nil
+  }
+  return out, nil
+}
+
+// runPostResponseHook passes response to the post_response hook, if any. The
+// hook's output goes to stdout, which should be os.Stderr when os.Stdout
+// carries -json output.
+func runPostResponseHook(config Config, response string, stdout io.Writer) {
+  script := config.Hooks[hookPostResponse]
+  if script == "" {
+    return
+  }
+
+  cmd := hookCommand(script, hookPostResponse, config)
+  cmd.Stdin = strings.NewReader(response)
+  cmd.Stdout = stdout
+  cmd.Stderr = os.Stderr
+  if err := cmd.Run(); err != nil {
+    slog.Warn("hook failed", "hook", hookPostResponse, "err", err)
+  }
+}
+
+func hookCommand(script, hook string, config Config) *exec.Cmd {
+  cmd := exec.Command("sh", "-c", script)
+  cmd.Env = append(os.Environ(),
+    "LLM_CLI_HOOK="+hook,
+    "LLM_CLI_MODEL="+config.Model,
+  )
+  return cmd
+}
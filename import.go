@@ -0,0 +1,134 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "os"
+  "regexp"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+  "time"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// chatGPTConversation is one entry of conversations.json from a ChatGPT data
+// export. Messages form a tree in Mapping; the path from CurrentNode back to
+// the root is the branch the user last saw.
+type chatGPTConversation struct {
+  ID             string                 `json:"id"`
+  ConversationID string                 `json:"conversation_id"`
+  Title          string                 `json:"title"`
+  CreateTime     float64                `json:"create_time"`
+  CurrentNode    string                 `json:"current_node"`
+  Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+  Parent  string `json:"parent"`
+  Message *struct {
+    Author struct {
+      Role string `json:"role"`
+    } `json:"author"`
+    Content struct {
+      ContentType string            `json:"content_type"`
+      Parts       []json.RawMessage `json:"parts"`
+    } `json:"content"`
+    Metadata struct {
+      Hidden bool `json:"is_visually_hidden_from_conversation"`
+    } `json:"metadata"`
+  } `json:"message"`
+}
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+func loadChatGPTExport(path string) ([]chatGPTConversation, error) {
+  content, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  var conversations []chatGPTConversation
+  if err := json.Unmarshal(content, &conversations); err != nil {
+    return nil, fmt.Errorf("parsing ChatGPT export %s: %w", path, err)
+  }
+  return conversations, nil
+}
+
+func (c chatGPTConversation) id() string {
+  if c.ConversationID != "" {
+    return c.ConversationID
+  }
+  return c.ID
+}
+
+// messages walks the current branch from the root, keeping visible user and
+// assistant text. System and tool messages are dropped.
+func (c chatGPTConversation) messages() []openai.ChatCompletionMessage {
+  var branch []openai.ChatCompletionMessage
+  for id, seen := c.CurrentNode, map[string]bool{}; id != "" && !seen[id]; id = c.Mapping[id].Parent {
+    seen[id] = true
+    msg := c.Mapping[id].Message
+    if msg == nil || msg.Metadata.Hidden {
+      continue
+    }
+    role := msg.Author.Role
+    if role != openai.ChatMessageRoleUser && role != openai.ChatMessageRoleAssistant {
+      continue
+    }
+
+    var parts []string
+    for _, raw := range msg.Content.Parts {
+      var text string
+      if json.Unmarshal(raw, &text) == nil && text != "" {
+        parts = append(parts, text)
+      }
+    }
+    if len(parts) == 0 {
+      continue
+    }
+    branch = append(branch, openai.ChatCompletionMessage{Role: role, Content: strings.Join(parts, "\n")})
+  }
+
+  for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+    branch[i], branch[j] = branch[j], branch[i]
+  }
+  return branch
+}
+
+func formatChatGPTConversations(conversations []chatGPTConversation) string {
+  var out strings.Builder
+  tw := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(tw, "#\tdate\tmessages\ttitle\tid")
+  for i, c := range conversations {
+    date := time.Unix(int64(c.CreateTime), 0).Format("2006-01-02")
+    fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", i+1, date, len(c.messages()), truncateValue(c.Title), c.id())
+  }
+  tw.Flush()
+  return strings.TrimSuffix(out.String(), "\n")
+}
+
+// findChatGPTConversation selects a conversation by ID or by its 1-based
+// position in the listing.
+func findChatGPTConversation(conversations []chatGPTConversation, selector string) (chatGPTConversation, error) {
+  for _, c := range conversations {
+    if c.id() == selector {
+      return c, nil
+    }
+  }
+  if n, err := strconv.Atoi(selector); err == nil && n >= 1 && n <= len(conversations) {
+    return conversations[n-1], nil
+  }
+  return chatGPTConversation{}, fmt.Errorf("no conversation %q in export", selector)
+}
+
+// importFileName derives a session file name from the conversation title.
+// Like :save's default, it is relative to the working directory, which is
+// where :load and its Tab completion look.
+func importFileName(c chatGPTConversation) string {
+  slug := strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(c.Title), "-"), "-")
+  if slug == "" {
+    slug = c.id()
+  }
+  return slug + ".json"
+}
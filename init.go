@@ -0,0 +1,91 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// initConfig is the part of Config that -init asks about. It is written
+// with marshalCanonicalJSON, so keys are sorted like every other config
+// write.
+type initConfig struct {
+  Model        string `json:"model"`
+  AIName       string `json:"ai_name"`
+  SystemPrompt string `json:"system_prompt"`
+  Style        string `json:"style"`
+}
+
+// runInit asks for the basic settings, offering the defaults, and writes
+// them to config.json in the user config directory. An existing file is
+// only replaced after confirmation.
+func runInit() error {
+  dir, err := userConfigDir()
+  if err != nil {
+    return err
+  }
+  path := filepath.Join(dir, "config.json")
+  editor := newLineEditor("")
+
+  ask := func(question, fallback string) (string, error) {
+    answer, err := editor.readLine(fmt.Sprintf("%s %s: ", question, dimStyle.Render("["+fallback+"]")))
+    if err != nil {
+      return "", err
+    }
+    if answer = strings.TrimSpace(answer); answer == "" {
+      return fallback, nil
+    }
+    return answer, nil
+  }
+
+  if _, err := os.Stat(path); err == nil {
+    answer, err := ask(path+" already exists. Overwrite it?", "y/N")
+    if err != nil {
+      return err
+    }
+    if answer = strings.ToLower(answer); answer != "y" && answer != "yes" {
+      fmt.Println("Left the existing config alone.")
+      return nil
+    }
+  }
+
+  defaults := defaultConfig()
+  var config initConfig
+  if config.Model, err = ask("Model", defaults.Model); err != nil {
+    return err
+  }
+  if config.AIName, err = ask("Assistant name", defaults.AIName); err != nil {
+    return err
+  }
+  if config.SystemPrompt, err = ask("System prompt", defaults.SystemPrompt); err != nil {
+    return err
+  }
+
+  styles := availableStyles()
+  if len(styles) > 0 {
+    fmt.Println("Styles:", strings.Join(styles, ", "))
+  }
+  for {
+    if config.Style, err = ask("Style", defaults.Style); err != nil {
+      return err
+    }
+    if styleExists(config.Style) {
+      break
+    }
+    fmt.Printf("No style named %s.\n", config.Style)
+  }
+
+  data, err := marshalCanonicalJSON(config)
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(dir, 0o755); err != nil {
+    return err
+  }
+  if _, err := writeFileAtomic(path, string(data), false); err != nil {
+    return err
+  }
+  fmt.Printf("Wrote %s.\n", path)
+  return nil
+}
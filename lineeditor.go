@@ -0,0 +1,373 @@
+package main
+
+import (
+  "bufio"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+
+  "github.com/charmbracelet/lipgloss"
+  "golang.org/x/term"
+)
+
+// maxHistory is how many entries are kept in memory and loaded from the
+// history file.
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when Ctrl-C is pressed. In raw
+// mode it arrives as a key rather than as SIGINT.
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads REPL input with readline-style editing: arrow keys and
+// the usual Ctrl shortcuts move and delete, and up/down recall earlier
+// input. Lines passed to remember are appended to a history file so they
+// can be recalled in later sessions. When stdin isn't a terminal it reads
+// plain lines.
+type lineEditor struct {
+  fd          int
+  history     []string
+  historyPath string
+  lines       *bufio.Scanner
+  // complete, when set, is called on Tab with the text before the cursor.
+  // It returns where the word being completed starts and what it could
+  // become; ok is false when there is nothing to complete, and the Tab is
+  // typed as is.
+  complete func(before []rune) (start int, candidates []string, ok bool)
+  // pending holds input read past the end of the last line, such as the
+  // rest of a multi-line paste.
+  pending []byte
+  // cursorRow is the row of the cursor relative to the prompt's first row.
+  cursorRow int
+}
+
+// historyFilePath returns where input history is kept:
+// $XDG_CONFIG_HOME/llm-cli/history, or ~/.config/llm-cli/history.
+func historyFilePath() (string, error) {
+  dir, err := userConfigDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(dir, "history"), nil
+}
+
+// newLineEditor returns an editor with the history in historyPath, which
+// may not exist yet. An empty path keeps history for this session only.
+func newLineEditor(historyPath string) *lineEditor {
+  e := &lineEditor{fd: int(os.Stdin.Fd()), historyPath: historyPath}
+  if !term.IsTerminal(e.fd) {
+    e.lines = bufio.NewScanner(os.Stdin)
+    return e
+  }
+  if historyPath != "" {
+    if data, err := os.ReadFile(historyPath); err == nil {
+      for _, line := range strings.Split(string(data), "\n") {
+        if line != "" {
+          e.history = append(e.history, line)
+        }
+      }
+      e.history = e.history[max(len(e.history)-maxHistory, 0):]
+    }
+  }
+  return e
+}
+
+// remember adds line to the history unless it is blank or repeats the
+// previous entry, and appends it to the history file.
+func (e *lineEditor) remember(line string) error {
+  if strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+    return nil
+  }
+  e.history = append(e.history, line)
+  if len(e.history) > maxHistory {
+    e.history = e.history[1:]
+  }
+  if e.historyPath == "" || e.lines != nil {
+    return nil
+  }
+
+  if err := os.MkdirAll(filepath.Dir(e.historyPath), 0o755); err != nil {
+    return err
+  }
+  f, err := os.OpenFile(e.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+  if err != nil {
+    return err
+  }
+  if _, err := f.WriteString(line + "\n"); err != nil {
+    f.Close()
+    return err
+  }
+  return f.Close()
+}
+
+// readLine shows prompt and returns the line typed, without the newline. It
+// returns io.EOF for Ctrl-D on an empty line and errInterrupted for Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+  return e.editLine(prompt, "")
+}
+
+// editLine is readLine starting from initial rather than an empty line.
+// Without a terminal, initial is ignored.
+func (e *lineEditor) editLine(prompt, initial string) (string, error) {
+  if e.lines != nil {
+    fmt.Print(prompt)
+    if e.lines.Scan() {
+      return e.lines.Text(), nil
+    }
+    if err := e.lines.Err(); err != nil {
+      return "", err
+    }
+    // A scanner stays at EOF once it has seen it, so a fresh one is needed
+    // for the next read.
+    e.lines = bufio.NewScanner(os.Stdin)
+    return "", io.EOF
+  }
+
+  state, err := term.MakeRaw(e.fd)
+  if err != nil {
+    return "", err
+  }
+  defer term.Restore(e.fd, state)
+
+  line := []rune(initial)
+  pos := len(line)
+  historyIndex := len(e.history)
+  var draft []rune
+  e.cursorRow = 0
+  e.refresh(prompt, line, pos)
+
+  buf := make([]byte, 256)
+  for {
+    if len(e.pending) == 0 {
+      n, err := os.Stdin.Read(buf)
+      if err != nil {
+        return "", err
+      }
+      e.pending = append(e.pending, buf[:n]...)
+    }
+
+    key, size := nextKey(e.pending)
+    if size == 0 {
+      // An incomplete sequence: wait for the rest of it.
+      n, err := os.Stdin.Read(buf)
+      if err != nil {
+        return "", err
+      }
+      e.pending = append(e.pending, buf[:n]...)
+      continue
+    }
+    e.pending = e.pending[size:]
+
+    // A Tab with more input right behind it is part of a paste, not a
+    // request to complete.
+    if key == "\t" && e.complete != nil && len(e.pending) == 0 {
+      if start, candidates, ok := e.complete(line[:pos]); ok {
+        line, pos = e.completeWord(prompt, line, pos, start, candidates)
+        e.refresh(prompt, line, pos)
+        continue
+      }
+    }
+
+    switch key {
+    case "\r", "\n":
+      e.refresh(prompt, line, len(line))
+      fmt.Print("\r\n")
+      return string(line), nil
+    case "\x03":
+      e.refresh(prompt, line, len(line))
+      fmt.Print("^C\r\n")
+      return "", errInterrupted
+    case "\x04":
+      if len(line) == 0 {
+        fmt.Print("\r\n")
+        return "", io.EOF
+      }
+      if pos < len(line) {
+        line = append(line[:pos], line[pos+1:]...)
+      }
+    case "\x7f", "\x08":
+      if pos > 0 {
+        line = append(line[:pos-1], line[pos:]...)
+        pos--
+      }
+    case "\x1b[3~":
+      if pos < len(line) {
+        line = append(line[:pos], line[pos+1:]...)
+      }
+    case "\x1b[D", "\x02":
+      pos = max(pos-1, 0)
+    case "\x1b[C", "\x06":
+      pos = min(pos+1, len(line))
+    case "\x1b[H", "\x1bOH", "\x1b[1~", "\x01":
+      pos = 0
+    case "\x1b[F", "\x1bOF", "\x1b[4~", "\x05":
+      pos = len(line)
+    case "\x1b[1;5D", "\x1bb":
+      pos = wordStart(line, pos)
+    case "\x1b[1;5C", "\x1bf":
+      pos = wordEnd(line, pos)
+    case "\x0b":
+      line = line[:pos]
+    case "\x15":
+      line = append([]rune{}, line[pos:]...)
+      pos = 0
+    case "\x17":
+      start := wordStart(line, pos)
+      line = append(line[:start], line[pos:]...)
+      pos = start
+    case "\x1b[A", "\x10":
+      if historyIndex == 0 {
+        break
+      }
+      if historyIndex == len(e.history) {
+        draft = line
+      }
+      historyIndex--
+      line = []rune(e.history[historyIndex])
+      pos = len(line)
+    case "\x1b[B", "\x0e":
+      if historyIndex == len(e.history) {
+        break
+      }
+      historyIndex++
+      if historyIndex == len(e.history) {
+        line = draft
+      } else {
+        line = []rune(e.history[historyIndex])
+      }
+      pos = len(line)
+    default:
+      r, _ := utf8.DecodeRuneInString(key)
+      if len(key) == utf8.RuneLen(r) && unicode.IsPrint(r) || key == "\t" {
+        line = append(line[:pos], append([]rune(key), line[pos:]...)...)
+        pos += utf8.RuneCountInString(key)
+      }
+    }
+    e.refresh(prompt, line, pos)
+  }
+}
+
+// completeWord replaces line[start:pos] with the longest prefix shared by
+// candidates. When that adds nothing and there is a choice, the candidates
+// are listed below the line; with no candidates, the terminal bell rings.
+func (e *lineEditor) completeWord(prompt string, line []rune, pos, start int, candidates []string) ([]rune, int) {
+  if len(candidates) == 0 {
+    fmt.Print("\a")
+    return line, pos
+  }
+
+  common := []rune(candidates[0])
+  for _, candidate := range candidates[1:] {
+    c := []rune(candidate)
+    n := 0
+    for n < len(common) && n < len(c) && common[n] == c[n] {
+      n++
+    }
+    common = common[:n]
+  }
+  if len(common) > pos-start || len(candidates) == 1 {
+    completed := append(append(append([]rune{}, line[:start]...), common...), line[pos:]...)
+    return completed, start + len(common)
+  }
+
+  e.refresh(prompt, line, len(line))
+  fmt.Print("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+  e.cursorRow = 0
+  return line, pos
+}
+
+// nextKey splits the first key off input: an escape sequence, a control
+// character or one UTF-8 encoded rune. It returns a size of 0 when input
+// ends partway through a key.
+func nextKey(input []byte) (string, int) {
+  if input[0] == 0x1b {
+    if len(input) == 1 {
+      return "\x1b", 1
+    }
+    switch input[1] {
+    case '[':
+      // CSI: parameters, then a final byte in 0x40-0x7e.
+      for i := 2; i < len(input); i++ {
+        if input[i] >= 0x40 && input[i] <= 0x7e {
+          return string(input[:i+1]), i + 1
+        }
+      }
+      return "", 0
+    case 'O':
+      if len(input) < 3 {
+        return "", 0
+      }
+      return string(input[:3]), 3
+    }
+    return string(input[:2]), 2
+  }
+  if !utf8.FullRune(input) {
+    return "", 0
+  }
+  _, size := utf8.DecodeRune(input)
+  return string(input[:size]), size
+}
+
+// refresh redraws the prompt and line and puts the cursor at pos, allowing
+// for lines that wrap past the terminal width.
+func (e *lineEditor) refresh(prompt string, line []rune, pos int) {
+  width, _, err := term.GetSize(int(os.Stdout.Fd()))
+  if err != nil || width <= 0 {
+    width = defaultWordWrap
+  }
+
+  var out strings.Builder
+  if e.cursorRow > 0 {
+    fmt.Fprintf(&out, "\x1b[%dA", e.cursorRow)
+  }
+  out.WriteString("\r\x1b[J")
+  out.WriteString(prompt)
+  out.WriteString(string(line))
+
+  promptWidth := lipgloss.Width(prompt)
+  end := promptWidth + lipgloss.Width(string(line))
+  if end > 0 && end%width == 0 {
+    // The cursor waits at the edge after filling a row; move it down so
+    // the row arithmetic below holds.
+    out.WriteString("\r\n")
+  }
+  endRow := end / width
+
+  cursor := promptWidth + lipgloss.Width(string(line[:pos]))
+  row, col := cursor/width, cursor%width
+  if up := endRow - row; up > 0 {
+    fmt.Fprintf(&out, "\x1b[%dA", up)
+  }
+  out.WriteString("\r")
+  if col > 0 {
+    fmt.Fprintf(&out, "\x1b[%dC", col)
+  }
+  e.cursorRow = row
+  fmt.Print(out.String())
+}
+
+// wordStart returns the position of the start of the word before pos.
+func wordStart(line []rune, pos int) int {
+  for pos > 0 && unicode.IsSpace(line[pos-1]) {
+    pos--
+  }
+  for pos > 0 && !unicode.IsSpace(line[pos-1]) {
+    pos--
+  }
+  return pos
+}
+
+// wordEnd returns the position of the end of the word after pos.
+func wordEnd(line []rune, pos int) int {
+  for pos < len(line) && unicode.IsSpace(line[pos]) {
+    pos++
+  }
+  for pos < len(line) && !unicode.IsSpace(line[pos]) {
+    pos++
+  }
+  return pos
+}
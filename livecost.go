@@ -0,0 +1,114 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "time"
+
+  "github.com/sashabaranov/go-openai"
+  "golang.org/x/term"
+)
+
+// liveCostInterval limits how often the status line is redrawn.
+const liveCostInterval = 100 * time.Millisecond
+
+// liveCost shows a running token and cost estimate on the bottom line of the
+// terminal while a response streams. The line is kept out of the way of the
+// streamed text with a scroll region covering the rest of the screen.
+//
+// A nil *liveCost is valid and does nothing, so callers need not check
+// whether the feature is enabled.
+type liveCost struct {
+  model       string
+  inputTokens int
+  rows        int
+  active      bool
+  drawn       time.Time
+}
+
+// newLiveCost returns nil unless config.LiveCost is set and stdout is a
+// terminal.
+func newLiveCost(config Config, messages []openai.ChatCompletionMessage) *liveCost {
+  if !config.LiveCost {
+    return nil
+  }
+  fd := int(os.Stdout.Fd())
+  if !term.IsTerminal(fd) {
+    return nil
+  }
+  _, rows, err := term.GetSize(fd)
+  if err != nil || rows < 2 {
+    return nil
+  }
+  return &liveCost{
+    model:       config.Model,
+    inputTokens: estimateMessageTokens(messages),
+    rows:        rows,
+  }
+}
+
+// start reserves the bottom line. It must be called at the start of a line.
+func (l *liveCost) start() {
+  if l == nil {
+    return
+  }
+  // Make sure there is a line below the cursor, then confine scrolling to
+  // everything above the last row. Setting the region homes the cursor, so
+  // it is saved and restored around it.
+  fmt.Printf("\n\x1b[1A\x1b7\x1b[1;%dr\x1b8", l.rows-1)
+  l.active = true
+  l.draw(0)
+}
+
+// update redraws the counter for the response received so far.
+func (l *liveCost) update(response string) {
+  if l == nil || !l.active || time.Since(l.drawn) < liveCostInterval {
+    return
+  }
+  l.draw(estimateTokens(response))
+}
+
+func (l *liveCost) draw(outputTokens int) {
+  status := fmt.Sprintf("~%s in / ~%s out tokens", formatTokenCount(l.inputTokens), formatTokenCount(outputTokens))
+  if cost, ok := estimateCost(l.model, l.inputTokens, outputTokens); ok {
+    status += ", ~" + formatCost(cost)
+  }
+  fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K%s\x1b8", l.rows, dimStyle.Render(status))
+  l.drawn = time.Now()
+}
+
+// stop clears the status line and restores normal scrolling. It is safe to
+// call more than once.
+func (l *liveCost) stop() {
+  if l == nil || !l.active {
+    return
+  }
+  fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K\x1b[r\x1b8", l.rows)
+  l.active = false
+}
+
+// report prints the final token counts and cost, using the usage reported
+// by the API when available and the running estimate otherwise.
+func (l *liveCost) report(usage *openai.Usage, response string) {
+  if l == nil {
+    return
+  }
+  l.stop()
+
+  estimatedOutput := estimateTokens(response)
+  estimate, priced := estimateCost(l.model, l.inputTokens, estimatedOutput)
+
+  var summary string
+  if usage != nil {
+    summary = fmt.Sprintf("%d in / %d out tokens", usage.PromptTokens, usage.CompletionTokens)
+    if cost, ok := estimateCost(l.model, usage.PromptTokens, usage.CompletionTokens); ok {
+      summary += fmt.Sprintf(", %s (estimated %s)", formatCost(cost), formatCost(estimate))
+    }
+  } else {
+    summary = fmt.Sprintf("~%d in / ~%d out tokens", l.inputTokens, estimatedOutput)
+    if priced {
+      summary += ", ~" + formatCost(estimate)
+    }
+  }
+  fmt.Println(dimStyle.Render(summary))
+}
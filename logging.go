@@ -0,0 +1,35 @@
+package main
+
+import (
+  "fmt"
+  "log/slog"
+  "os"
+)
+
+// logLevel is the minimum level written to stderr, set by -log-level.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging makes the default slog logger write text records to stderr so
+// diagnostics never mix with responses on stdout. Timestamps are dropped;
+// they only add noise for a short-lived command.
+func setupLogging() {
+  handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+    Level: logLevel,
+    ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+      if len(groups) == 0 && a.Key == slog.TimeKey {
+        return slog.Attr{}
+      }
+      return a
+    },
+  })
+  slog.SetDefault(slog.New(handler))
+}
+
+// parseLogLevel accepts debug, info, warn or error.
+func parseLogLevel(value string) (slog.Level, error) {
+  var level slog.Level
+  if err := level.UnmarshalText([]byte(value)); err != nil {
+    return level, fmt.Errorf("log level must be debug, info, warn or error, got %q", value)
+  }
+  return level, nil
+}
@@ -1,54 +1,218 @@
 package main
 
 import (
-  "bufio"
+  "bytes"
   "context"
   "encoding/json"
+  "errors"
   "flag"
   "fmt"
+  "io"
+  "io/fs"
+  "log/slog"
+  "math"
+  "net/http"
   "os"
+  "os/signal"
   "os/user"
+  "path/filepath"
+  "slices"
+  "strconv"
   "strings"
+  "sync"
+  "time"
 
   "github.com/charmbracelet/lipgloss"
   "github.com/charmbracelet/glamour"
+  "github.com/charmbracelet/glamour/ansi"
+  "github.com/muesli/termenv"
   "github.com/sashabaranov/go-openai"
+  "golang.org/x/term"
 )
 
 type Config struct {
-	Model         string `json:"model"`
-	AIName        string `json:"ai_name"`
-	SystemPrompt  string `json:"system_prompt"`
-	Style         string `json:"style"`
+	Model               string            `json:"model"`
+	AIName              string            `json:"ai_name"`
+	SystemPrompt        string            `json:"system_prompt"`
+	SystemPrompts       []string          `json:"system_prompts"`
+	Style               string            `json:"style"`
+	Mode                string            `json:"mode"`
+	AssistantID         string            `json:"assistant_id"`
+	FallbackModels      []string          `json:"fallback_models"`
+	Temperature         *float32          `json:"temperature,omitempty"`
+	ShowContextMeter    bool              `json:"show_context_meter"`
+	Hooks               map[string]string `json:"hooks"`
+	SanitizeInput       *bool             `json:"sanitize_input,omitempty"`
+	ResponseSchema      string            `json:"response_schema"`
+	SchemaRetries       int               `json:"schema_retries"`
+	MaxTokens           int               `json:"max_tokens,omitempty"`
+	FileUpdateMode      string            `json:"file_update_mode"`
+	PreserveNewlines    bool              `json:"preserve_newlines"`
+	Margin              *uint             `json:"margin,omitempty"`
+	InjectDateTime      bool              `json:"inject_datetime"`
+	DateTimeFormat      string            `json:"datetime_format"`
+	CritiquePrompt      string            `json:"critique_prompt"`
+	ModelRouting        []routingRule     `json:"model_routing"`
+	DuplicateThreshold  float64           `json:"duplicate_threshold"`
+	StreamStopMarker    string            `json:"stream_stop_marker"`
+	LiveCost            bool              `json:"live_cost"`
+	Stateless           bool              `json:"stateless"`
+	RenderCitations     bool              `json:"render_citations"`
+	WarnOnSpike         bool              `json:"warn_on_spike"`
+	AutoFormatJSON      bool              `json:"auto_format_json"`
+	WordWrap            int               `json:"word_wrap"`
+	MultilineTerminator string            `json:"multiline_terminator"`
+	AutoSavePath        string            `json:"autosave_path"`
+	AltScreen           bool              `json:"alt_screen"`
+	Stream              bool              `json:"stream"`
+	CompactOutput       bool              `json:"compact_output"`
+	TopP                *float32          `json:"top_p,omitempty"`
+	ShowUsage           bool              `json:"show_usage"`
+	BaseURL             string            `json:"base_url,omitempty"`
+	MaxRetries          *int              `json:"max_retries,omitempty"`
+	MaxContextTokens    int               `json:"max_context_tokens,omitempty"`
+	Plain               bool              `json:"plain"`
+	NoColor             bool              `json:"no_color"`
+	Provider            string            `json:"provider,omitempty"`
+	AzureEndpoint       string            `json:"azure_endpoint,omitempty"`
+	AzureDeployment     string            `json:"azure_deployment,omitempty"`
+	OrgID               string            `json:"org_id,omitempty"`
+	Stop                []string          `json:"stop,omitempty"`
+	PresencePenalty     float32           `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    float32           `json:"frequency_penalty,omitempty"`
+	Seed                *int              `json:"seed,omitempty"`
+	ResponseFormat      string            `json:"response_format,omitempty"`
+	EmbeddingModel      string            `json:"embedding_model,omitempty"`
+	Moderate            bool              `json:"moderate"`
+	TimeoutSeconds      int               `json:"timeout_seconds,omitempty"`
+	Profiles            configProfiles    `json:"profiles,omitempty"`
+	ServeToken          string            `json:"serve_token,omitempty"`
+
+	// responseSchema holds the contents of ResponseSchema once loaded.
+	responseSchema json.RawMessage
+	// modelChosen is set when the model was picked with -m or :model, which
+	// model_routing then leaves alone.
+	modelChosen bool
+	// overrides are the flags and environment variables applied over the
+	// config file, kept to apply again over a profile switched to.
+	overrides *runOverrides
+	// profile is the name of the profile applied, if any, and unprofiled
+	// the config file's settings without it, which :profile starts from.
+	profile    string
+	unprofiled *Config
 }
 
 const (
-  cmdQuit =   ":q"
-  cmdMulti =  ":multi"
-  cmdEnd =    ":end"
-  cmdRemove = ":remove"
-  cmdFile =   ":file "
+  cmdQuit =        ":q"
+  cmdMulti =       ":multi"
+  cmdEnd =         ":end"
+  cmdRemove =      ":remove"
+  cmdFile =        ":file "
+  cmdDump =        ":dump"
+  cmdTemp =        ":temp"
+  cmdStream =      ":stream"
+  cmdScratch =     ":scratch"
+  cmdScratchSend = ":scratch-send"
+  cmdTruncateTo =  ":truncate-to"
+  cmdConfigDiff =  ":config-diff"
+  cmdPersona =     ":persona"
+  cmdPersonas =    ":personas"
+  cmdGitDiff =     ":gitdiff"
+  cmdWhy =         ":why"
+  cmdPreview =     ":preview"
+  cmdMaxTokens =   ":max-tokens"
+  cmdRegenTemp =   ":regen-temp"
+  cmdWrap =        ":wrap"
+  cmdRaw =         ":raw"
+  cmdCapture =     ":capture"
+  cmdSet =         ":set"
+  cmdVars =        ":vars"
+  cmdSave =        ":save"
+  cmdLoad =        ":load"
+  cmdClear =       ":clear"
+  cmdModel =       ":model"
+  cmdSystem =      ":system"
+  cmdFiles =       ":files"
+  cmdRemoveFile =  ":remove-file"
+  cmdHelp =        ":help"
+  cmdRegenerate =  ":regenerate"
+  cmdUndo =        ":undo"
+  cmdEdit =        ":edit"
+  cmdImage =       ":image "
+  cmdURL =         ":url "
+  cmdProfile =     ":profile"
+)
+
+// Values for Config.FileUpdateMode, which controls what is sent when a file
+// already in context is added again with changes.
+const (
+  fileUpdateFull = "full"
+  fileUpdateDiff = "diff"
 )
 
-func loadConfig(path string) (Config, error) {
+// maxDumpContentLen caps how much of each message :dump prints.
+const maxDumpContentLen = 2000
+
+// loadConfig reads the config file at path. A non-empty profile names an
+// entry in its profiles to apply.
+func loadConfig(path, profile string) (Config, error) {
   var config Config
   file, err := os.Open(path)
   if err != nil {
+    switch {
+    case errors.Is(err, fs.ErrNotExist):
+      return config, fmt.Errorf("config file %s not found", path)
+    case errors.Is(err, fs.ErrPermission):
+      return config, fmt.Errorf("config file %s is not readable: permission denied (check its permissions, e.g. chmod 600 %s)", path, path)
+    }
     return config, err
   }
   defer file.Close()
 
+  info, err := file.Stat()
+  if err != nil {
+    return config, err
+  }
+  if info.IsDir() {
+    return config, fmt.Errorf("config path %s is a directory, expected a JSON file", path)
+  }
+  if info.Size() == 0 {
+    return config, fmt.Errorf("config file %s is empty", path)
+  }
+
   decoder := json.NewDecoder(file)
-  err = decoder.Decode(&config)
-  return config, err
+  decoder.DisallowUnknownFields()
+  if err := decoder.Decode(&config); err != nil {
+    return config, fmt.Errorf("parsing %s: %w", path, describeDecodeError(err))
+  }
+  if profile != "" {
+    config, err = applyProfile(config, profile)
+    if err != nil {
+      return config, fmt.Errorf("%s: %w", path, err)
+    }
+    return config, nil
+  }
+  if err := validateConfig(config); err != nil {
+    return config, fmt.Errorf("%s: %w", path, err)
+  }
+  unprofiled := config
+  config.unprofiled = &unprofiled
+  return config, nil
 }
 
 func main() {
-  config, err := loadConfig("config.json")
-  if err != nil {
-    fmt.Printf("Error loading config: %v\n", err)
-    os.Exit(1)
-  }
+  setupLogging()
+  timings := newPhaseTimings()
+  start := time.Now()
+
+  var configPath string
+  flag.StringVar(&configPath, "config", "", "Path to the config file (default: the first config.json in $XDG_CONFIG_HOME/llm-cli, ~/.config/llm-cli or the working directory)")
+  flag.StringVar(&configPath, "c", "", "Config shorthand")
+  var profile string
+  flag.StringVar(&profile, "profile", "", "Apply this profile from the config file's profiles")
+  flag.StringVar(&profile, "P", "", "Profile shorthand")
+  initialize := flag.Bool("init", false, "Create config.json in the user config directory by answering a few questions, then exit")
+  listStyles := flag.Bool("styles", false, "List the available styles, with a preview of each on a terminal, then exit")
 
   var prompt string
   flag.StringVar(&prompt, "prompt", "", "Prompt for the LLM")
@@ -56,195 +220,1731 @@ func main() {
 
   interactive := flag.Bool("interactive", false, "Run in interactive mode")
   flag.BoolVar(interactive, "i", false, "Interactive shorthand")
+  var overrides runOverrides
+  flag.BoolVar(&overrides.stateless, "stateless", false, "In interactive mode, send each message without the conversation history")
+
+  flag.StringVar(&overrides.persona, "persona", "", "Use a named persona as the system prompt (list them with :personas)")
+
+  importPath := flag.String("import", "", "ChatGPT export (conversations.json) to list or import from")
+  conversation := flag.String("conversation", "", "With -import, the conversation ID or list number to import")
+
+  var gitDiffOpt gitDiffFlag
+  flag.Var(&gitDiffOpt, "gitdiff", "Add `git diff` output to the prompt")
+  flag.Func("gitdiff-args", "Arguments for -gitdiff's git diff, e.g. HEAD~3 or --staged; implies -gitdiff", gitDiffOpt.setArgs)
+
+  renderPath := flag.String("render", "", "Render a markdown file (or - for stdin) with the configured style and exit")
+
+  useClipboard := flag.Bool("clipboard", false, "Use the clipboard contents as the prompt (appended to -p if both are given)")
+
+  selfCritique := flag.Bool("self-critique", false, "Ask the model to critique and improve its answer before showing it")
+  showDraft := flag.Bool("show-draft", false, "With -self-critique, also show the first draft")
+
+  scriptPath := flag.String("script", "", "Run the user turns in this file (separated by "+scriptDelimiter+" lines, - for stdin) as one conversation")
+  jsonOutput := flag.Bool("json", false, "Print the prompt, model, response and token usage as one JSON object instead of rendering the response; with -script, the whole transcript")
+
+  batchPath := flag.String("batch", "", "Run each line of this file (- for stdin) as an independent prompt")
+  estimate := flag.Bool("estimate", false, "With -batch, print estimated tokens and cost per prompt instead of running")
+  outputRatio := flag.Float64("output-ratio", 1, "With -estimate, expected output tokens as a multiple of input tokens")
+
+  serveAddr := flag.String("serve", "", "Serve a local HTTP API (POST /v1/chat, GET /health) on this address, e.g. localhost:8080")
+
+  flag.BoolVar(&overrides.stream, "stream", false, "Print responses token by token as they arrive (not with -self-critique or in assistant mode)")
+
+  flag.StringVar(&overrides.wrap, "wrap", "", "Wrap responses at this many columns, or auto for the terminal width (the default)")
+
+  flag.BoolVar(&overrides.noColor, "no-color", false, "Disable colors and styling (also set by the NO_COLOR environment variable)")
+
+  flag.BoolVar(&overrides.plain, "plain", false, "Print responses as raw text with no markdown rendering, colors or header (the default when stdout is not a terminal)")
+
+  flag.BoolVar(&overrides.showUsage, "show-usage", false, "Print the prompt, completion and total tokens reported for each response")
+
+  var outputPath string
+  flag.StringVar(&outputPath, "output", "", "Write the raw response text to this file instead of rendering it (non-interactive mode)")
+  flag.StringVar(&outputPath, "o", "", "Output shorthand")
+
+  writeCode := flag.String("write-code", "", "Write the first code block of the response to this file, atomically (non-interactive mode)")
+  backup := flag.Bool("backup", false, "With -write-code, keep the previous version of the file as <file>.bak")
+
+  logLevelName := flag.String("log-level", "info", "Minimum level of diagnostics written to stderr: debug, info, warn or error")
+
+  profileTiming := flag.Bool("profile-timing", false, "Print a breakdown of where time was spent (non-interactive mode)")
+
+  timeout := flag.Int("timeout", 0, "Seconds to wait for each request before giving up (default: timeout_seconds from config, or no limit)")
+  retries := flag.Int("max-retries", -1, "Retries for rate-limited, failed or timed-out requests (default: max_retries from config, or 2)")
+
+  flag.StringVar(&overrides.baseURL, "base-url", "", "OpenAI-compatible API base URL, e.g. http://localhost:11434/v1 for Ollama")
+
+  flag.StringVar(&overrides.responseFormat, "response-format", "", "Response format: text, or json to make the model reply with a JSON object, printed unrendered (the system prompt must mention JSON)")
+
+  flag.StringVar(&overrides.embeddingModel, "embedding-model", "", "With embed, the embedding model to use (default: embedding_model from config, or "+defaultEmbeddingModel+")")
+
+  var images stringList
+  flag.Var(&images, "image", "Attach this image to the prompt for a vision model; repeat for several (non-interactive mode)")
+
+  flag.Var(&overrides.stop, "stop", "Stop generating when the model outputs this sequence; repeat for several (replaces stop from config)")
+
+  flag.StringVar(&overrides.model, "model", "", "Model to use instead of the configured one")
+  flag.StringVar(&overrides.model, "m", "", "Model shorthand")
+
+  flag.StringVar(&overrides.temperature, "T", "", "Temperature for this run only, 0-2 (overrides $"+envTemperature+" and config)")
+  flag.StringVar(&overrides.maxTokens, "M", "", "Max completion tokens for this run only (overrides $"+envMaxTokens+" and config)")
+  flag.StringVar(&overrides.temperature, "temperature", "", "Alias for -T")
+  flag.StringVar(&overrides.maxTokens, "max-tokens", "", "Alias for -M")
+  flag.StringVar(&overrides.topP, "top-p", "", "Nucleus sampling top_p for this run only, 0-1 (overrides $"+envTopP+" and config)")
+  flag.StringVar(&overrides.presencePenalty, "presence-penalty", "", "Presence penalty for this run only, -2 to 2; positive values favor new topics")
+  flag.StringVar(&overrides.frequencyPenalty, "frequency-penalty", "", "Frequency penalty for this run only, -2 to 2; positive values discourage repeating lines")
+  flag.StringVar(&overrides.seed, "seed", "", "Seed for this run only, so repeated requests give the same response where the model supports it")
+  flag.StringVar(&overrides.systemPrompt, "S", "", "System prompt for this run only (overrides $"+envSystemPrompt+" and config)")
 
   flag.Parse()
+  if *retries >= 0 {
+    overrides.maxRetries = retries
+  }
+  overrides.timeout = *timeout
 
-  apiKey := os.Getenv("OPENAI_API_KEY")
-  if apiKey == "" {
-    fmt.Println("Error: OPENAI_API_KEY not found in env")
+  // Subcommands come before their flags, which are parsed from the
+  // arguments that follow: llm-cli embed -p "text".
+  subcommand := flag.Arg(0)
+  if subcommand == embedCommand {
+    flag.CommandLine.Parse(flag.Args()[1:])
+  }
+
+  level, err := parseLogLevel(*logLevelName)
+  if err != nil {
+    slog.Error("parsing -log-level", "err", err)
     os.Exit(1)
   }
+  logLevel.Set(level)
 
-  client := openai.NewClient(apiKey)
+  if *initialize {
+    if err := runInit(); err != nil {
+      slog.Error("creating config", "err", err)
+      os.Exit(1)
+    }
+    return
+  }
+  if *listStyles {
+    if err := printStyles(); err != nil {
+      slog.Error("listing styles", "err", err)
+      os.Exit(1)
+    }
+    return
+  }
 
-  if *interactive {
-    runInteractiveMode(client, config)
-  } else {
-    if prompt == "" {
-      fmt.Println("Error: prompt is required in non-interactive mode")
-      flag.Usage()
+  if configPath == "" {
+    configPath, err = findConfigFile("config.json")
+    if err != nil {
+      slog.Error("loading config", "err", err)
       os.Exit(1)
     }
+  }
+  slog.Debug("using config", "path", configPath)
+  config, err := loadConfig(configPath, profile)
+  if err != nil {
+    slog.Error("loading config", "err", err)
+    os.Exit(1)
+  }
 
-    response, err := callOpenAI(client, config, []openai.ChatCompletionMessage{
-      {Role: openai.ChatMessageRoleSystem, Content: config.SystemPrompt},
-      {Role: openai.ChatMessageRoleUser, Content: prompt},
-    })
+  if config.ResponseSchema != "" {
+    config.responseSchema, err = loadResponseSchema(config.ResponseSchema)
     if err != nil {
-      fmt.Printf("Error: %v\n", err)
+      slog.Error("loading config", "err", err)
       os.Exit(1)
     }
+  }
+
+  if err := applyOverrides(&config, overrides); err != nil {
+    slog.Error("applying overrides", "err", err)
+    os.Exit(1)
+  }
+  if config.Plain || config.NoColor {
+    lipgloss.SetColorProfile(termenv.Ascii)
+  }
+  if config.ResponseFormat == responseFormatJSON && !strings.Contains(strings.ToLower(systemPrompt(config)), "json") {
+    slog.Warn("JSON response format needs the word JSON in the system prompt, or the API rejects the request")
+  }
 
-    err = printFormattedResponse(response, config.Style, config.AIName, config.Model)
+  timings.record("config load", start)
+
+  if *renderPath != "" {
+    var markdown []byte
+    if *renderPath == "-" {
+      markdown, err = io.ReadAll(os.Stdin)
+    } else {
+      markdown, err = os.ReadFile(*renderPath)
+    }
+    if err != nil {
+      slog.Error("reading markdown", "err", err)
+      os.Exit(1)
+    }
+    out, err := renderMarkdown(string(markdown), config)
     if err != nil {
-      fmt.Printf("Error formatting response: %v\n", err)
+      slog.Error("rendering markdown", "err", err)
       os.Exit(1)
     }
+    fmt.Print(out)
+    return
   }
-}
 
-func runInteractiveMode(client *openai.Client, config Config) {
-  fmt.Printf("Entering interactive mode. Type %s to exit or %s to enter multiline mode.\n", cmdQuit, cmdMulti)
-  fmt.Println()
+  var history []openai.ChatCompletionMessage
+  if *importPath != "" {
+    conversations, err := loadChatGPTExport(*importPath)
+    if err != nil {
+      slog.Error("reading ChatGPT export", "err", err)
+      os.Exit(1)
+    }
+    if *conversation == "" {
+      fmt.Println(formatChatGPTConversations(conversations))
+      return
+    }
 
-  scanner := bufio.NewScanner(os.Stdin)
-  messages := []openai.ChatCompletionMessage{
-    {Role: openai.ChatMessageRoleSystem, Content: config.SystemPrompt},
+    selected, err := findChatGPTConversation(conversations, *conversation)
+    if err != nil {
+      slog.Error("selecting conversation", "err", err)
+      os.Exit(1)
+    }
+    history = append([]openai.ChatCompletionMessage{
+      {Role: openai.ChatMessageRoleSystem, Content: systemPrompt(config)},
+    }, selected.messages()...)
+
+    sessionPath, err := writeNewSession(importFileName(selected), history)
+    if err != nil {
+      slog.Error("writing session", "err", err)
+      os.Exit(1)
+    }
+    slog.Info("imported conversation", "title", selected.Title, "messages", len(history)-1, "path", sessionPath)
+    if !*interactive {
+      return
+    }
   }
 
-  var contextFile string
-  isMultiline := false
-  var lines []string
+  var batch []string
+  if *batchPath != "" {
+    batch, err = loadBatch(*batchPath)
+    if err != nil {
+      slog.Error("loading batch", "err", err)
+      os.Exit(1)
+    }
+    if *estimate {
+      if *outputRatio < 0 {
+        slog.Error("-output-ratio must not be negative", "value", *outputRatio)
+        os.Exit(1)
+      }
+      printBatchEstimate(os.Stdout, config, batch, *outputRatio)
+      return
+    }
+  }
 
-  for {
-    currentDir := getCurrentDirectory()
-    inputPrefix := formatInputPrefix(currentDir, isMultiline, config.AIName)
-    fmt.Print(inputPrefix)
+  start = time.Now()
+  backend, err := newProvider(config)
+  if err != nil {
+    slog.Error("setting up client", "err", err)
+    os.Exit(1)
+  }
 
-    if isMultiline {
-      fmt.Println()
-      for scanner.Scan() {                                                              
-        line := scanner.Text()
+  if config.Mode != "" && config.Mode != modeChat && config.Mode != modeAssistant {
+    slog.Error("unknown mode", "mode", config.Mode, "expected", modeChat+" or "+modeAssistant)
+    os.Exit(1)
+  }
+  if config.Mode == modeAssistant && openAIClient(backend) == nil {
+    slog.Error("assistant mode needs the openai provider", "provider", config.Provider)
+    os.Exit(1)
+  }
+  if config.Moderate && openAIClient(backend) == nil {
+    slog.Error("moderation needs the openai provider", "provider", config.Provider)
+    os.Exit(1)
+  }
+  if config.Stream && openAIClient(backend) == nil {
+    slog.Warn("streaming is only available with the openai provider", "provider", config.Provider)
+    config.Stream = false
+  }
 
-        if strings.HasPrefix(line, ":") {
-          command := strings.ToLower(line)
+  timings.record("client setup", start)
 
-          if command == cmdMulti {
-            fmt.Println("Exiting multiline mode.")
-            fmt.Println()
-            isMultiline = false
-            lines = nil
-            break
-          }
-          if command == cmdRemove {
-            if len(lines) > 0 {
-              lines = lines[:len(lines)-1]
-              fmt.Println("Last line removed.")
-              fmt.Println()
-            } else {
-              fmt.Println("No lines to remove.")
-              fmt.Println()
-            }
-            continue
-          }
-          if command == cmdEnd {
-            break
-          }
+  // Piped input becomes (part of) the prompt, unless another option already
+  // reads stdin or the server is running.
+  if !term.IsTerminal(int(os.Stdin.Fd())) && *serveAddr == "" && *scriptPath != "-" && *batchPath != "-" {
+    if *interactive {
+      slog.Warn("stdin is not a terminal; running non-interactively")
+      *interactive = false
+    }
+    piped, err := io.ReadAll(os.Stdin)
+    if err != nil {
+      slog.Error("reading stdin", "err", err)
+      os.Exit(1)
+    }
+    if looksBinary(piped) {
+      slog.Error("stdin appears to be binary; only text can be sent")
+      os.Exit(1)
+    }
+    if text := strings.TrimSpace(string(piped)); text != "" {
+      if prompt != "" {
+        prompt = prompt + "\n\n" + text
+      } else {
+        prompt = text
+      }
+    }
+  }
+
+  if *useClipboard {
+    clip, err := readClipboard()
+    if err != nil {
+      slog.Error("reading clipboard", "err", err)
+      os.Exit(1)
+    }
+    if strings.TrimSpace(clip) == "" {
+      slog.Error("clipboard is empty")
+      os.Exit(1)
+    }
+    if prompt != "" {
+      prompt = prompt + "\n\n" + clip
+    } else {
+      prompt = clip
+    }
+  }
+
+  if subcommand == embedCommand {
+    if err := runEmbed(context.Background(), backend, config, prompt); err != nil {
+      slog.Error("creating embedding", "err", err)
+      os.Exit(1)
+    }
+    return
+  }
+
+  if gitDiffOpt.enabled {
+    diff, err := gitDiff(gitDiffOpt.args)
+    if err != nil {
+      slog.Error("running git diff", "err", err)
+      os.Exit(1)
+    }
+    diffContext := gitDiffContext(gitDiffOpt.args, diff)
+    if *interactive {
+      if len(history) == 0 {
+        history = []openai.ChatCompletionMessage{
+          {Role: openai.ChatMessageRoleSystem, Content: systemPrompt(config)},
         }
+      }
+      history = append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: diffContext})
+    } else {
+      prompt = diffContext + "\n\n" + prompt
+    }
+  }
 
-        lines = append(lines, line)
+  if *serveAddr != "" {
+    if err := serve(*serveAddr, backend, config); err != nil {
+      slog.Error("serving", "err", err)
+      os.Exit(1)
+    }
+    return
+  }
+
+  if batch != nil {
+    if err := runBatch(backend, config, batch); err != nil {
+      slog.Error("running batch", "err", err)
+      os.Exit(1)
+    }
+    return
+  }
+
+  if *scriptPath != "" {
+    turns, err := loadScript(*scriptPath)
+    if err != nil {
+      slog.Error("loading script", "err", err)
+      os.Exit(1)
+    }
+    if err := runScript(backend, config, turns, *jsonOutput); err != nil {
+      slog.Error("running script", "err", err)
+      os.Exit(1)
+    }
+    return
+  }
+
+  if *interactive {
+    runInteractiveMode(backend, config, prompt, history)
+  } else {
+    if prompt == "" {
+      slog.Error("prompt is required in non-interactive mode")
+      flag.Usage()
+      os.Exit(1)
+    }
+
+    if shouldSanitize(config) {
+      prompt = sanitizeInput(prompt)
+    }
+    prompt, err = runPreRequestHook(config, prompt)
+    if err != nil {
+      slog.Error("request cancelled", "err", err)
+      os.Exit(1)
+    }
+    if err := moderateInput(context.Background(), backend, config, prompt); err != nil {
+      slog.Error("request not sent", "err", err)
+      os.Exit(1)
+    }
+
+    messages := []openai.ChatCompletionMessage{
+      {Role: openai.ChatMessageRoleSystem, Content: systemPrompt(config)},
+      {Role: openai.ChatMessageRoleUser, Content: prompt},
+    }
+
+    var thread *assistantThread
+    if config.Mode == modeAssistant {
+      thread, err = newAssistantThread(context.Background(), openAIClient(backend), config)
+      if err != nil {
+        slog.Error("starting assistant thread", "err", err)
+        os.Exit(1)
+      }
+    }
+    if thread == nil {
+      config.Model = routeTurn(config, prompt)
+    }
+    if len(images) > 0 {
+      if err := checkVision(config); err != nil {
+        slog.Error("attaching images", "err", err)
+        os.Exit(1)
+      }
+      var parts []openai.ChatMessagePart
+      for _, path := range images {
+        part, err := loadImage(path)
+        if err != nil {
+          slog.Error("attaching images", "err", err)
+          os.Exit(1)
+        }
+        parts = append(parts, part)
+      }
+      messages[1] = imageMessage(prompt, parts)
+    }
+    complete := func(messages []openai.ChatCompletionMessage) (completion, error) {
+      if thread != nil {
+        content, err := thread.complete(context.Background(), messages)
+        return completion{content: content}, err
+      }
+      return callModel(context.Background(), backend, config, messages)
+    }
+    // The spinner is left out of -json output, which should hold nothing
+    // but the JSON even on a terminal.
+    waiting := func() *spinner {
+      if *jsonOutput {
+        return nil
       }
+      return startSpinner(config.Model)
+    }
 
-      if err := scanner.Err(); err != nil {
-        fmt.Printf("Error reading input: %v\n", err)
-        continue
+    // A streamed response is printed as it arrives, so it isn't rendered
+    // again below. Self-critique needs the whole draft first, so it and
+    // assistant mode always wait for the full response, as does writing it
+    // to a file with -output.
+    streamed := config.Stream && thread == nil && !*selfCritique && outputPath == "" && !*jsonOutput
+
+    start = time.Now()
+    var result completion
+    if streamed {
+      result, err = streamOpenAI(context.Background(), openAIClient(backend), config, messages)
+    } else {
+      spin := waiting()
+      result, err = complete(messages)
+      spin.stop()
+    }
+    if err != nil {
+      slog.Error("request failed", "err", err)
+      os.Exit(1)
+    }
+    response, usage := result.content, result.usage
+    // answered is config with the model that gave the response, and
+    // draftConfig the same for the self-critique draft.
+    answered := answeredConfig(config, result)
+    var draftConfig Config
+
+    var draft string
+    var critiqueTokens int
+    if *selfCritique {
+      draft = response
+      critique := append(messages,
+        openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: draft},
+        openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: critiquePrompt(config)},
+      )
+      spin := waiting()
+      refined, err := complete(critique)
+      spin.stop()
+      if err != nil {
+        slog.Error("self-critique failed", "err", err)
+        os.Exit(1)
       }
+      response, usage = refined.content, addUsage(usage, refined.usage)
+      draftConfig, answered = answered, answeredConfig(config, refined)
+      critiqueTokens = estimateMessageTokens(critique) + estimateTokens(response)
+    }
 
-      if len(lines) > 0 {
-        combinedInput := strings.Join(lines, "\n")
-        messages = append(messages, openai.ChatCompletionMessage{
-          Role: openai.ChatMessageRoleUser,
-          Content: combinedInput,
-        })
+    timings.record("api call", start)
 
-        response, err := callOpenAI(client, config, messages)
+    start = time.Now()
+    if outputPath != "" {
+      // The file gets the text as the model wrote it, without styling or
+      // the response header.
+      text := response
+      if !strings.HasSuffix(text, "\n") {
+        text += "\n"
+      }
+      if _, err := writeFileAtomic(outputPath, text, false); err != nil {
+        slog.Error("writing response", "path", outputPath, "err", err)
+        os.Exit(1)
+      }
+      slog.Info("wrote response", "path", outputPath, "bytes", len(text))
+    } else if *jsonOutput {
+      if err := printJSONResult(prompt, answered.Model, response, usage); err != nil {
+        slog.Error("encoding response", "err", err)
+        os.Exit(1)
+      }
+    } else {
+      if *selfCritique && *showDraft {
+        fmt.Println(dimStyle.Render("Draft:"))
+        if err := printFormattedResponse(draft, draftConfig); err != nil {
+          slog.Error("rendering response", "err", err)
+          os.Exit(1)
+        }
+        fmt.Println(dimStyle.Render("Refined:"))
+      }
+      if !streamed {
+        err = printFormattedResponse(response, answered)
         if err != nil {
-          fmt.Printf("Error communicating with AI: %v\n", err)
-          continue
+          slog.Error("rendering response", "err", err)
+          os.Exit(1)
         }
+      }
+    }
+    if config.ShowUsage && thread == nil && !*jsonOutput {
+      fmt.Println(dimStyle.Render(formatUsage(usage, nil)))
+    }
+    if *selfCritique {
+      slog.Info("self-critique used extra tokens", "estimated", formatTokenCount(critiqueTokens))
+    }
+    timings.record("rendering", start)
 
-        messages = append(messages, openai.ChatCompletionMessage{
-          Role: openai.ChatMessageRoleAssistant,
-          Content: response,
-        })
+    if *writeCode != "" {
+      code, ok := firstCodeBlock(response)
+      if !ok {
+        slog.Error("no complete code block in the response; nothing written", "path", *writeCode)
+        os.Exit(1)
+      }
+      backupPath, err := writeFileAtomic(*writeCode, code, *backup)
+      if err != nil {
+        slog.Error("writing code block", "path", *writeCode, "err", err)
+        os.Exit(1)
+      }
+      if backupPath != "" {
+        slog.Info("wrote code block", "path", *writeCode, "lines", strings.Count(code, "\n"), "backup", backupPath)
+      } else {
+        slog.Info("wrote code block", "path", *writeCode, "lines", strings.Count(code, "\n"))
+      }
+    }
+
+    hookOutput := io.Writer(os.Stdout)
+    if *jsonOutput {
+      hookOutput = os.Stderr
+    }
+    runPostResponseHook(config, response, hookOutput)
+
+    if *profileTiming {
+      fmt.Fprintln(os.Stderr)
+      timings.print(os.Stderr)
+    }
+  }
+}
+
+// jsonResult is what -json prints for a single prompt.
+type jsonResult struct {
+  Prompt   string       `json:"prompt"`
+  Model    string       `json:"model"`
+  Response string       `json:"response"`
+  Usage    openai.Usage `json:"usage"`
+}
+
+// printJSONResult writes the outcome of a single prompt to stdout as JSON,
+// with nothing else on stdout, for piping into tools like jq.
+func printJSONResult(prompt, model, response string, usage openai.Usage) error {
+  out, err := marshalCanonicalJSON(jsonResult{Prompt: prompt, Model: model, Response: response, Usage: usage})
+  if err != nil {
+    return err
+  }
+  _, err = os.Stdout.Write(out)
+  return err
+}
+
+// runInteractiveMode starts the REPL. A non-empty history continues an
+// existing conversation, and a non-empty initialPrompt is sent as the first
+// turn before any input is read.
+func runInteractiveMode(backend provider, config Config, initialPrompt string, history []openai.ChatCompletionMessage) {
+  // blankLine ends a block of output of the given kind before the next
+  // prompt; separatorAfter decides the spacing.
+  blankLine := func(kind outputKind) {
+    fmt.Print(separatorAfter(kind, config.CompactOutput))
+  }
 
-        err = printFormattedResponse(response, config.Style, config.AIName, config.Model)
+  fmt.Printf("Entering interactive mode. Type %s to exit, %s to enter multiline mode or %s for all commands.\n", cmdQuit, cmdMulti, cmdHelp)
+  if config.Stateless {
+    fmt.Println("Stateless: each message is sent with only the system prompt, without earlier turns or added files.")
+  }
+  blankLine(outputCommand)
+
+  // History is kept across sessions; without a config directory it lasts
+  // for this session only.
+  historyPath, err := historyFilePath()
+  if err != nil {
+    slog.Debug("input history is not saved", "err", err)
+  }
+  editor := newLineEditor(historyPath)
+  editor.complete = completeInput
+  messages := []openai.ChatCompletionMessage{
+    {Role: openai.ChatMessageRoleSystem, Content: systemPrompt(config)},
+  }
+  if len(history) > 0 {
+    messages = history
+  }
+
+  var thread *assistantThread
+  if config.Mode == modeAssistant {
+    var err error
+    thread, err = newAssistantThread(context.Background(), openAIClient(backend), config)
+    if err != nil {
+      slog.Error("starting assistant thread", "err", err)
+      blankLine(outputError)
+      return
+    }
+  }
+
+  // complete sends the conversation through whichever backend is configured.
+  complete := func(ctx context.Context, turnConfig Config, messages []openai.ChatCompletionMessage) (completion, error) {
+    if thread != nil {
+      content, err := thread.complete(ctx, messages)
+      return completion{content: content}, err
+    }
+    return callModel(ctx, backend, turnConfig, messages)
+  }
+
+  // stream selects token-by-token output instead of a rendered response.
+  stream := config.Stream && thread == nil
+  if config.Stream && thread != nil {
+    slog.Warn("streaming is not available in assistant mode")
+  }
+
+  // With AutoSavePath set, the conversation is saved as it changes and on
+  // SIGINT, SIGTERM or SIGHUP, so an interrupted session is not lost.
+  var autosave *autoSaver
+  if config.AutoSavePath != "" {
+    autosave = &autoSaver{path: config.AutoSavePath}
+    autosave.saveOnSignal()
+  }
+
+  // Ctrl-C cancels the request in flight and returns to the prompt. At the
+  // prompt, it exits.
+  interruptExit := func() {
+    if autosave != nil {
+      if err := autosave.save(); err != nil {
+        slog.Error("saving conversation", "path", autosave.path, "err", err)
+        os.Exit(1)
+      }
+    }
+    fmt.Println("Exiting interactive mode.")
+    os.Exit(0)
+  }
+  var cancelMu sync.Mutex
+  var cancelTurn context.CancelFunc
+  interrupts := make(chan os.Signal, 1)
+  signal.Notify(interrupts, os.Interrupt)
+  defer signal.Stop(interrupts)
+  go func() {
+    for range interrupts {
+      cancelMu.Lock()
+      cancel := cancelTurn
+      cancelMu.Unlock()
+      if cancel != nil {
+        cancel()
+        continue
+      }
+
+      fmt.Println()
+      interruptExit()
+    }
+  }()
+  // turnContext returns the context for one request, cancelled by Ctrl-C,
+  // and a func to call when the request is done.
+  //
+  // Every request is made from the REPL loop, on this goroutine, and waits
+  // for its response, so at most one is ever in flight: messages, lastError
+  // and the terminal need no locking. Only cancelTurn is shared, with the
+  // signal goroutine above. Anything that makes requests concurrently must
+  // serialize them here first.
+  turnContext := func() (context.Context, func()) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancelMu.Lock()
+    cancelTurn = cancel
+    cancelMu.Unlock()
+    return ctx, func() {
+      cancelMu.Lock()
+      cancelTurn = nil
+      cancelMu.Unlock()
+      cancel()
+    }
+  }
+  saveProgress := func() {
+    if autosave == nil {
+      return
+    }
+    if err := autosave.update(messages); err != nil {
+      slog.Error("saving conversation", "path", autosave.path, "err", err)
+    }
+  }
+
+  // lastError is the failed API call of the previous turn, if any, for :why.
+  var lastError *turnError
+
+  // vars holds values captured with :capture and :set, referenced in
+  // messages as ${name}.
+  vars := map[string]string{}
+
+  // sessionUsage totals the tokens reported for every response this
+  // session, shown with ShowUsage.
+  var sessionUsage openai.Usage
+  // showUsage prints a response's usage and the running total.
+  showUsage := func(usage openai.Usage) {
+    sessionUsage = addUsage(sessionUsage, usage)
+    if config.ShowUsage && thread == nil {
+      fmt.Println(dimStyle.Render(formatUsage(usage, &sessionUsage)))
+    }
+  }
+
+  // confirmNext is set by :preview so the next turn asks before sending.
+  confirmNext := false
+
+  // contextFiles are the paths added with :file, in the order they were
+  // first added.
+  var contextFiles []string
+  // fileVersions remembers what was last sent for each :file path so a
+  // re-added file can be diffed against it.
+  fileVersions := map[string]string{}
+
+  // forgetDroppedFiles forgets the added files whose context messages are
+  // no longer in the conversation, so a re-added file is sent in full.
+  forgetDroppedFiles := func() {
+    contextFiles = slices.DeleteFunc(contextFiles, func(name string) bool {
+      if slices.ContainsFunc(messages, func(msg openai.ChatCompletionMessage) bool { return isFileContextMessage(msg, name) }) {
+        return false
+      }
+      delete(fileVersions, name)
+      return true
+    })
+  }
+
+  // pendingImages are attached with :image to the next message sent.
+  var pendingImages []openai.ChatMessagePart
+
+  // reply requests a response to messages, which end with the user's turn,
+  // and appends it. A cancelled or failed request is reported and its error
+  // returned, with messages left as they were. previous is the response
+  // before this one, if any, to compare against.
+  reply := func(turnConfig Config, previous string) error {
+    ctx, done := turnContext()
+    var result completion
+    var err error
+    if stream {
+      result, err = streamOpenAI(ctx, openAIClient(backend), turnConfig, messages)
+    } else {
+      spin := startSpinner(turnConfig.Model)
+      result, err = complete(ctx, turnConfig, messages)
+      spin.stop()
+    }
+    done()
+    if errors.Is(err, context.Canceled) {
+      fmt.Println("Request cancelled.")
+      blankLine(outputError)
+      return err
+    }
+    if err != nil {
+      lastError = &turnError{err: err, model: turnConfig.Model}
+      slog.Error("request failed", "err", err)
+      fmt.Printf("Type %s for an explanation.\n", cmdWhy)
+      blankLine(outputError)
+      return err
+    }
+    lastError = nil
+    response := result.content
+
+    messages = append(messages, openai.ChatCompletionMessage{
+      Role: openai.ChatMessageRoleAssistant,
+      Content: response,
+    })
+
+    if !stream {
+      err = printFormattedResponse(response, answeredConfig(turnConfig, result))
+      if err != nil {
+        slog.Error("rendering response", "err", err)
+      }
+    }
+    showUsage(result.usage)
+    if previous != "" && isNearDuplicate(config, previous, response) {
+      slog.Info("response is very similar to the previous one")
+    }
+    runPostResponseHook(config, response, os.Stdout)
+    blankLine(outputResponse)
+    return nil
+  }
+
+  // send runs one turn: the user message is passed through the pre_request
+  // hook, sent with the history, and the reply is printed and recorded. It
+  // reports whether the message was added to the history. It isn't when a
+  // hook or moderation stops it, the confirmation is declined or the request
+  // is cancelled; a message whose request failed is kept so it can be
+  // retried.
+  send := func(content string) bool {
+    if shouldSanitize(config) {
+      content = sanitizeInput(content)
+    }
+    content, missing := expandVariables(content, vars)
+    for _, name := range missing {
+      slog.Warn("undefined variable left as written", "name", name)
+    }
+    content, err := runPreRequestHook(config, content)
+    if err != nil {
+      slog.Error("request cancelled", "err", err)
+      blankLine(outputError)
+      return false
+    }
+    if err := moderateInput(context.Background(), backend, config, content); err != nil {
+      slog.Error("message not sent", "err", err)
+      blankLine(outputError)
+      return false
+    }
+
+    if config.WarnOnSpike {
+      if tokens, baseline, spike := tokenSpike(messages, content); spike {
+        slog.Warn("this message is much larger than your recent ones", "tokens", formatTokenCount(tokens), "recent_average", formatTokenCount(baseline))
+      }
+    }
+
+    previous, _ := lastAssistantMessage(messages)
+    if config.Stateless {
+      // Every turn stands alone: only the system prompt is carried over.
+      messages = []openai.ChatCompletionMessage{messages[0]}
+    }
+
+    if len(pendingImages) > 0 {
+      messages = append(messages, imageMessage(content, pendingImages))
+      pendingImages = nil
+    } else {
+      messages = append(messages, openai.ChatCompletionMessage{
+        Role: openai.ChatMessageRoleUser,
+        Content: content,
+      })
+    }
+    if config.MaxContextTokens > 0 {
+      var dropped int
+      messages, dropped = trimContext(messages, config.MaxContextTokens)
+      if dropped > 0 {
+        fmt.Println(dimStyle.Render(fmt.Sprintf("Dropped the %d oldest messages to stay within %s tokens.", dropped, formatTokenCount(config.MaxContextTokens))))
+        forgetDroppedFiles()
+      }
+    }
+    saveProgress()
+
+    turnConfig := config
+    if thread == nil {
+      turnConfig.Model = routeTurn(config, content)
+    }
+
+    if confirmNext {
+      confirmNext = false
+      answer, err := editor.readLine(fmt.Sprintf("Send about %s tokens to %s? [y/N] ", formatTokenCount(estimateMessageTokens(messages)), turnConfig.Model))
+      if errors.Is(err, errInterrupted) {
+        interruptExit()
+      }
+      answer = strings.ToLower(strings.TrimSpace(answer))
+      if answer != "y" && answer != "yes" {
+        messages = messages[:len(messages)-1]
+        fmt.Println("Not sent.")
+        blankLine(outputCommand)
+        return false
+      }
+    }
+
+    if err := reply(turnConfig, previous); errors.Is(err, context.Canceled) {
+      // The message was never answered, so it isn't kept in the history.
+      messages = messages[:len(messages)-1]
+      return false
+    }
+    return true
+  }
+
+  var scratch string
+
+  // addFileContext adds a file's content to the conversation. A file added
+  // before is sent again in full or as a diff, per FileUpdateMode, and not
+  // at all if it hasn't changed.
+  addFileContext := func(fileName, content string) {
+    if !slices.Contains(contextFiles, fileName) {
+      contextFiles = append(contextFiles, fileName)
+    }
+    fileContext := fileContentMessage(fileName, content)
+
+    if previous, seen := fileVersions[fileName]; seen {
+      diff := unifiedDiff(fileName+" (previous)", fileName, previous, content)
+      if diff == "" {
+        fmt.Printf("%s has not changed since it was added.\n", fileName)
+        return
+      }
+      fmt.Print(diff)
+      if config.FileUpdateMode == fileUpdateDiff {
+        fileContext = fileDiffMessage(fileName, diff)
+      } else {
+        fileContext = fileUpdateMessage(fileName, content)
+      }
+    }
+    fileVersions[fileName] = content
+
+    messages = append(messages, openai.ChatCompletionMessage{
+      Role: openai.ChatMessageRoleUser,
+      Content: fileContext,
+    })
+    fmt.Printf("Added %s to the context.\n", fileName)
+  }
+
+  // clearConversation drops everything but the system prompt, along with
+  // the added files and their remembered versions.
+  clearConversation := func() {
+    messages = messages[:1]
+    contextFiles = nil
+    clear(fileVersions)
+    lastError = nil
+    fmt.Println("Conversation cleared.")
+    blankLine(outputCommand)
+  }
+
+  isMultiline := false
+  var lines []string
+  terminator := config.MultilineTerminator
+  if terminator == "" {
+    terminator = cmdEnd
+  }
+
+  if initialPrompt != "" {
+    if shouldSanitize(config) {
+      initialPrompt = sanitizeInput(initialPrompt)
+    }
+    fmt.Println(formatInputPrefix(getCurrentDirectory(), false, config.Stateless, "") + initialPrompt)
+    send(initialPrompt)
+  }
+
+  for {
+    saveProgress()
+
+    currentDir := getCurrentDirectory()
+    var contextMeter string
+    if config.ShowContextMeter {
+      contextMeter = formatContextMeter(messages, config.Model)
+    }
+    inputPrefix := formatInputPrefix(currentDir, isMultiline, config.Stateless, contextMeter)
+
+    if isMultiline {
+      fmt.Println(inputPrefix)
+      terminated := false
+      var readErr error
+      for {
+        line, err := editor.readLine("")
+        if err != nil {
+          readErr = err
+          break
+        }
+
+        if strings.EqualFold(line, terminator) {
+          terminated = true
+          break
+        }
+        if strings.HasPrefix(line, ":") {
+          command := strings.ToLower(line)
+
+          if command == cmdMulti {
+            fmt.Println("Exiting multiline mode.")
+            blankLine(outputCommand)
+            isMultiline = false
+            lines = nil
+            break
+          }
+          if command == cmdClear {
+            clearConversation()
+            continue
+          }
+          if command == cmdRemove {
+            if len(lines) > 0 {
+              lines = lines[:len(lines)-1]
+              fmt.Println("Last line removed.")
+              blankLine(outputCommand)
+            } else {
+              fmt.Println("No lines to remove.")
+              blankLine(outputCommand)
+            }
+            continue
+          }
+        }
+        // A leading backslash lets content start with ":".
+        if strings.HasPrefix(line, `\:`) {
+          line = line[1:]
+        }
+
+        lines = append(lines, line)
+      }
+
+      if errors.Is(readErr, errInterrupted) {
+        interruptExit()
+      }
+      if readErr != nil && readErr != io.EOF {
+        slog.Error("reading input", "err", readErr)
+        blankLine(outputError)
+        continue
+      }
+      if !terminated && isMultiline {
+        // Ctrl-D ends the message too, or the session if nothing was typed.
+        if len(lines) == 0 {
+          fmt.Println("Exiting interactive mode.")
+          blankLine(outputCommand)
+          return
+        }
+      }
+
+      if len(lines) > 0 {
+        send(strings.Join(lines, "\n"))
+        lines = nil
+      }
+    } else {
+      userInput, err := editor.readLine(inputPrefix)
+      if errors.Is(err, errInterrupted) {
+        interruptExit()
+      }
+      if err == io.EOF {
+        fmt.Println("Exiting interactive mode.")
+        blankLine(outputCommand)
+        return
+      }
+      if err != nil {
+        slog.Error("reading input", "err", err)
+        blankLine(outputError)
+        continue
+      }
+      if err := editor.remember(userInput); err != nil {
+        slog.Warn("saving input history", "err", err)
+      }
+
+      if strings.ToLower(userInput) == cmdQuit {
+        fmt.Println("Exiting interactive mode.")
+        blankLine(outputCommand)
+        return
+      }
+      if strings.ToLower(userInput) == cmdMulti {
+        fmt.Printf("Multiline mode. Type %s or press Ctrl-D to finish input, %s to delete the most recent line.\n", terminator, cmdRemove)
+        fmt.Println(`Start a line with \ to send content that begins with ":".`)
+        blankLine(outputCommand)
+        isMultiline = true
+        lines = nil
+        continue
+      }
+      if strings.ToLower(userInput) == cmdHelp {
+        fmt.Println(formatHelp())
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdClear {
+        clearConversation()
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdModel {
+        if len(fields) == 1 {
+          fmt.Printf("Model: %s\n", config.Model)
+          blankLine(outputCommand)
+          continue
+        }
+        if len(fields) != 2 {
+          fmt.Printf("Usage: %s [name]\n", cmdModel)
+          blankLine(outputCommand)
+          continue
+        }
+        if thread != nil {
+          fmt.Println("The model is set by the assistant in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        // The name isn't checked here; an unknown model fails the next turn
+        // like any other API error.
+        config.Model = fields[1]
+        config.modelChosen = true
+        fmt.Printf("Switched to %s.\n", config.Model)
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdDump {
+        dump, err := dumpMessages(messages)
+        if err != nil {
+          slog.Error("dumping messages", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        fmt.Println(dump)
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdStream {
+        if thread != nil {
+          fmt.Println("Streaming is not available in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        if openAIClient(backend) == nil {
+          fmt.Println("Streaming is only available with the openai provider.")
+          blankLine(outputCommand)
+          continue
+        }
+        stream = !stream
+        if stream {
+          fmt.Println("Streaming on.")
+        } else {
+          fmt.Println("Streaming off.")
+        }
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdWhy {
+        if lastError == nil {
+          fmt.Println("The last turn did not fail.")
+          blankLine(outputCommand)
+          continue
+        }
+        ctx, done := turnContext()
+        explanation, err := explainError(ctx, backend, config, *lastError)
+        done()
+        if err != nil {
+          slog.Error("explaining error", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        if err := printFormattedResponse(explanation, config); err != nil {
+          slog.Error("rendering response", "err", err)
+        }
+        blankLine(outputResponse)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdSave {
+        if len(fields) > 2 {
+          fmt.Printf("Usage: %s [file]\n", cmdSave)
+          blankLine(outputCommand)
+          continue
+        }
+        path := defaultSessionName(time.Now())
+        if len(fields) == 2 {
+          path = fields[1]
+        }
+        if err := saveSession(path, messages, vars); err != nil {
+          slog.Error("saving conversation", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        fmt.Printf("Saved the conversation to %s.\n", path)
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdLoad {
+        if len(fields) != 2 {
+          fmt.Printf("Usage: %s <file>\n", cmdLoad)
+          blankLine(outputCommand)
+          continue
+        }
+        loaded, loadedVars, err := loadSession(fields[1])
+        if err != nil {
+          slog.Error("loading conversation", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        if loaded[0].Role != openai.ChatMessageRoleSystem {
+          loaded = append([]openai.ChatCompletionMessage{messages[0]}, loaded...)
+        }
+        messages, vars = loaded, loadedVars
+        contextFiles = nil
+        clear(fileVersions)
+        lastError = nil
+
+        // Replay the turns inline; the alternate screen would stop after
+        // each long response.
+        replayConfig := config
+        replayConfig.AltScreen = false
+        for _, msg := range messages[1:] {
+          switch msg.Role {
+          case openai.ChatMessageRoleUser:
+            fmt.Println(formatInputPrefix(getCurrentDirectory(), false, config.Stateless, "") + messageText(msg))
+          case openai.ChatMessageRoleAssistant:
+            if err := printFormattedResponse(messageText(msg), replayConfig); err != nil {
+              slog.Error("rendering response", "err", err)
+            }
+          }
+          blankLine(outputResponse)
+        }
+        fmt.Printf("Loaded the conversation from %s.\n", fields[1])
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdVars {
+        fmt.Println(formatVariables(vars))
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdCapture {
+        if len(fields) != 2 || !variableName.MatchString(fields[1]) {
+          fmt.Printf("Usage: %s <name> (letters, digits and underscores)\n", cmdCapture)
+          blankLine(outputCommand)
+          continue
+        }
+        last, ok := lastAssistantMessage(messages)
+        if !ok {
+          fmt.Println("No response to capture yet.")
+          blankLine(outputCommand)
+          continue
+        }
+        vars[fields[1]] = last
+        fmt.Printf("Captured the last response as ${%s}.\n", fields[1])
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdSet {
+        name, instruction, err := parseSetCommand(strings.TrimSpace(userInput[len(fields[0]):]))
+        if err != nil {
+          slog.Error("setting variable", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        ctx, done := turnContext()
+        value, err := extractVariable(ctx, backend, config, messages, instruction)
+        done()
+        if err != nil {
+          slog.Error("extracting variable", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        vars[name] = value
+        fmt.Printf("${%s} = %s\n", name, truncateValue(value))
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdRaw {
+        last, ok := lastAssistantMessage(messages)
+        if !ok {
+          fmt.Println("No response yet.")
+          blankLine(outputCommand)
+          continue
+        }
+        fmt.Println(last)
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdPreview {
+        fmt.Println(formatPreview(config, messages, fileVersions, pendingImages))
+        fmt.Println("The next message will ask for confirmation before it is sent.")
+        blankLine(outputCommand)
+        confirmNext = true
+        continue
+      }
+      if strings.ToLower(userInput) == cmdConfigDiff {
+        diff, err := formatConfigDiff(config)
+        if err != nil {
+          slog.Error("comparing config", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        fmt.Println(diff)
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdProfile {
+        if len(fields) == 1 {
+          fmt.Println(formatProfileList(config))
+          blankLine(outputCommand)
+          continue
+        }
+        if len(fields) != 2 {
+          fmt.Printf("Usage: %s [name]\n", cmdProfile)
+          blankLine(outputCommand)
+          continue
+        }
+        next, err := switchProfile(config, fields[1])
+        if err != nil {
+          slog.Error("switching profile", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        // An assistant thread lives on the server, so a session can't move
+        // in or out of assistant mode.
+        if thread != nil || next.Mode == modeAssistant {
+          fmt.Println("Profiles can't be switched to or from assistant mode during a session.")
+          blankLine(outputCommand)
+          continue
+        }
+        nextBackend, err := newProvider(next)
+        if err == nil && next.Moderate && openAIClient(nextBackend) == nil {
+          err = fmt.Errorf("moderation needs the %s provider", providerOpenAI)
+        }
+        if err != nil {
+          slog.Error("switching profile", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        config, backend = next, nextBackend
+        if config.Plain || config.NoColor {
+          lipgloss.SetColorProfile(termenv.Ascii)
+        }
+        stream = config.Stream && openAIClient(backend) != nil
+        messages = setSystemMessage(messages, systemPrompt(config))
+        fmt.Printf("Switched to profile %s (%s).\n", config.profile, config.Model)
+        // Images were checked against the old model when attached.
+        if len(pendingImages) > 0 {
+          if err := checkVision(config); err != nil {
+            slog.Warn("dropped attached images", "count", len(pendingImages), "err", err)
+            pendingImages = nil
+          }
+        }
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdPersonas {
+        list, err := formatPersonaList()
+        if err != nil {
+          slog.Error("listing personas", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        fmt.Println(list)
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdPersona {
+        if len(fields) != 2 {
+          fmt.Printf("Usage: %s <name>\n", cmdPersona)
+          blankLine(outputCommand)
+          continue
+        }
+        if thread != nil {
+          fmt.Println("The system prompt is set by the assistant in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        prompt, err := lookupPersona(fields[1])
+        if err != nil {
+          slog.Error("selecting persona", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        config.SystemPrompt = prompt
+        config.SystemPrompts = nil
+        messages = setSystemMessage(messages, systemPrompt(config))
+        fmt.Printf("Switched to persona %s.\n", fields[1])
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdSystem {
+        text := strings.TrimSpace(userInput[len(fields[0]):])
+        if text == "" {
+          if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem && messages[0].Content != "" {
+            fmt.Println(messages[0].Content)
+          } else {
+            fmt.Println("No system prompt.")
+          }
+          blankLine(outputCommand)
+          continue
+        }
+        if thread != nil {
+          fmt.Println("The system prompt is set by the assistant in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        config.SystemPrompt = text
+        config.SystemPrompts = nil
+        messages = setSystemMessage(messages, systemPrompt(config))
+        fmt.Printf("System prompt set: %s\n", truncateValue(text))
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdScratch {
+        last, ok := lastAssistantMessage(messages)
+        if !ok {
+          fmt.Println("No response to edit yet.")
+          blankLine(outputCommand)
+          continue
+        }
+        edited, err := editInEditor(last)
+        if err != nil {
+          slog.Error("editing scratch buffer", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        scratch = edited
+        fmt.Printf("Scratch buffer saved. Type %s to send it.\n", cmdScratchSend)
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdScratchSend {
+        if strings.TrimSpace(scratch) == "" {
+          fmt.Printf("Scratch buffer is empty. Use %s first.\n", cmdScratch)
+          blankLine(outputCommand)
+          continue
+        }
+        content := scratch
+        scratch = ""
+        send(content)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdGitDiff {
+        args := fields[1:]
+        diff, err := gitDiff(args)
+        if err != nil {
+          slog.Error("running git diff", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        messages = append(messages, openai.ChatCompletionMessage{
+          Role: openai.ChatMessageRoleUser,
+          Content: gitDiffContext(args, diff),
+        })
+        fmt.Printf("Added %s (%d lines) to the context.\n", gitDiffLabel(args), strings.Count(diff, "\n"))
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdTruncateTo {
+        if len(fields) != 2 {
+          fmt.Printf("Usage: %s <index>\n", cmdTruncateTo)
+          blankLine(outputCommand)
+          continue
+        }
+        index, err := strconv.Atoi(fields[1])
+        if err != nil {
+          slog.Error("parsing index", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        if index < 0 || index >= len(messages) {
+          slog.Error("index out of range", "min", 0, "max", len(messages)-1)
+          blankLine(outputError)
+          continue
+        }
+        removed := len(messages) - (index + 1)
+        messages = messages[:index+1]
+        forgetDroppedFiles()
+        fmt.Printf("Removed %d messages. History now has %d messages.\n", removed, len(messages))
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdTemp {
+        if len(fields) == 1 {
+          fmt.Printf("Temperature: %s\n", formatTemperature(config.Temperature))
+          blankLine(outputCommand)
+          continue
+        }
+        temperature, err := parseTemperature(fields[1])
+        if err != nil {
+          slog.Error("setting temperature", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        config.Temperature = &temperature
+        fmt.Printf("Temperature set to %s.\n", formatTemperature(config.Temperature))
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdEdit {
+        if thread != nil {
+          fmt.Println("Editing is not available in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        last := len(messages) - 1
+        for last > 0 && messages[last].Role != openai.ChatMessageRoleUser {
+          last--
+        }
+        if last == 0 {
+          fmt.Println("No message to edit yet.")
+          blankLine(outputCommand)
+          continue
+        }
+
+        // Without $EDITOR, a one-line message is edited in place at the
+        // prompt; anything longer still needs an editor.
+        original := messageText(messages[last])
+        var edited string
+        var err error
+        if os.Getenv("EDITOR") == "" && !strings.Contains(original, "\n") {
+          edited, err = editor.editLine(dimStyle.Render("Edit: "), original)
+          if errors.Is(err, errInterrupted) {
+            interruptExit()
+          }
+        } else {
+          edited, err = editInEditor(original)
+        }
+        if err != nil {
+          slog.Error("editing message", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        edited = strings.TrimSpace(edited)
+        if edited == "" || edited == strings.TrimSpace(original) {
+          fmt.Println("Message unchanged.")
+          blankLine(outputCommand)
+          continue
+        }
+
+        // The reply to the old message is stale, so it goes too. If the
+        // edit isn't sent after all, the conversation is put back.
+        // Images sent with the message are sent again with the edit.
+        previous, previousImages := slices.Clone(messages), pendingImages
+        pendingImages = messageImages(messages[last])
+        messages = messages[:last]
+        if !send(edited) {
+          messages, pendingImages = previous, previousImages
+        }
+        continue
+      }
+      if strings.ToLower(userInput) == cmdUndo {
+        if len(messages) <= 1 {
+          fmt.Println("Nothing to undo.")
+          blankLine(outputCommand)
+          continue
+        }
+        // A response goes with the message it answered; an unanswered
+        // message, such as one whose request failed, goes on its own.
+        n := 1
+        last := len(messages) - 1
+        if messages[last].Role == openai.ChatMessageRoleAssistant && last >= 2 && messages[last-1].Role == openai.ChatMessageRoleUser {
+          n = 2
+        }
+        messages = messages[:len(messages)-n]
+        forgetDroppedFiles()
+        lastError = nil
+        if n == 2 {
+          fmt.Println("Removed the last message and its response.")
+        } else {
+          fmt.Println("Removed the last message.")
+        }
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdRegenerate {
+        if thread != nil {
+          fmt.Println("Regenerating is not available in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        if len(messages) < 3 || messages[len(messages)-1].Role != openai.ChatMessageRoleAssistant {
+          fmt.Println("The last message is not a response, so there is nothing to regenerate.")
+          blankLine(outputCommand)
+          continue
+        }
+        previous := messages[len(messages)-1]
+        messages = messages[:len(messages)-1]
+        turnConfig := config
+        turnConfig.Model = routeTurn(config, messageText(messages[len(messages)-1]))
+        if err := reply(turnConfig, previous.Content); err != nil {
+          // Keep the old response rather than leave the turn unanswered.
+          messages = append(messages, previous)
+        }
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdRegenTemp {
+        showDiff := len(fields) == 3 && fields[2] == "-diff"
+        if len(fields) != 2 && !showDiff {
+          fmt.Printf("Usage: %s <temperature> [-diff]\n", cmdRegenTemp)
+          blankLine(outputCommand)
+          continue
+        }
+        if thread != nil {
+          fmt.Println("Regenerating is not available in assistant mode.")
+          blankLine(outputCommand)
+          continue
+        }
+        previous, ok := lastAssistantMessage(messages)
+        if !ok || messages[len(messages)-1].Role != openai.ChatMessageRoleAssistant {
+          fmt.Println("No response to regenerate yet.")
+          blankLine(outputCommand)
+          continue
+        }
+        temperature, err := parseTemperature(fields[1])
+        if err != nil {
+          slog.Error("setting temperature", "err", err)
+          blankLine(outputError)
+          continue
+        }
+
+        // The session temperature is left alone; only this request uses
+        // the new value. Otherwise it is a turn like :regenerate's.
+        last := messages[len(messages)-1]
+        messages = messages[:len(messages)-1]
+        turnConfig := config
+        turnConfig.Temperature = &temperature
+        turnConfig.Model = routeTurn(config, messageText(messages[len(messages)-1]))
+        if err := reply(turnConfig, previous); err != nil {
+          messages = append(messages, last)
+          continue
+        }
+        if showDiff {
+          response := messages[len(messages)-1].Content
+          if diff := unifiedDiff("previous", "regenerated", previous, response); diff != "" {
+            fmt.Print(diff)
+          } else {
+            fmt.Println("The regenerated response is identical.")
+          }
+          blankLine(outputCommand)
+        }
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdWrap {
+        if len(fields) == 1 {
+          fmt.Printf("Wrap width: %s\n", formatWordWrap(config))
+          blankLine(outputCommand)
+          continue
+        }
+        rerender := len(fields) == 3 && fields[2] == "-render"
+        if len(fields) != 2 && !rerender {
+          fmt.Printf("Usage: %s [<n>|auto] [-render]\n", cmdWrap)
+          blankLine(outputCommand)
+          continue
+        }
+        width, err := parseWordWrap(fields[1])
+        if err != nil {
+          slog.Error("setting wrap width", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        config.WordWrap = width
+        fmt.Printf("Wrap width set to %s.\n", formatWordWrap(config))
+        if last, ok := lastAssistantMessage(messages); ok && rerender {
+          if err := printFormattedResponse(last, config); err != nil {
+            slog.Error("rendering response", "err", err)
+          }
+        }
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdMaxTokens {
+        if len(fields) == 1 {
+          fmt.Printf("Max tokens: %s\n", formatMaxTokens(config.MaxTokens))
+          blankLine(outputCommand)
+          continue
+        }
+        if len(fields) != 2 {
+          fmt.Printf("Usage: %s [<n>|off]\n", cmdMaxTokens)
+          blankLine(outputCommand)
+          continue
+        }
+        maxTokens := 0
+        if strings.ToLower(fields[1]) != "off" {
+          n, err := strconv.Atoi(fields[1])
+          if err != nil || n <= 0 {
+            slog.Error("max tokens must be a positive integer or off", "value", fields[1])
+            blankLine(outputError)
+            continue
+          }
+          if limit := outputLimit(config.Model); limit > 0 && n > limit {
+            slog.Error("max tokens exceeds the model's limit", "value", n, "model", config.Model, "limit", limit)
+            blankLine(outputError)
+            continue
+          }
+          maxTokens = n
+        }
+        config.MaxTokens = maxTokens
+        fmt.Printf("Max tokens set to %s.\n", formatMaxTokens(config.MaxTokens))
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.ToLower(userInput) == cmdFiles {
+        fmt.Println(formatContextFiles(contextFiles, fileVersions))
+        blankLine(outputCommand)
+        continue
+      }
+      if fields := strings.Fields(userInput); len(fields) > 0 && strings.ToLower(fields[0]) == cmdRemoveFile {
+        fileName := strings.TrimSpace(userInput[len(fields[0]):])
+        if !slices.Contains(contextFiles, fileName) {
+          fmt.Printf("%s is not in the context. Type %s to list the files that are.\n", fileName, cmdFiles)
+          blankLine(outputCommand)
+          continue
+        }
+        contextFiles = slices.DeleteFunc(contextFiles, func(name string) bool { return name == fileName })
+        delete(fileVersions, fileName)
+        messages = removeFileContext(messages, fileName)
+        fmt.Printf("Removed %s from the context.\n", fileName)
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.HasPrefix(userInput, cmdURL) {
+        pageURL := strings.TrimSpace(strings.TrimPrefix(userInput, cmdURL))
+        ctx, done := turnContext()
+        text, err := fetchURL(ctx, pageURL)
+        done()
+        if err != nil {
+          slog.Error("fetching page", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        addFileContext(pageURL, text)
+        blankLine(outputCommand)
+        continue
+      }
+      if strings.HasPrefix(userInput, cmdImage) {
+        if err := checkVision(config); err != nil {
+          slog.Error("attaching image", "err", err)
+          blankLine(outputError)
+          continue
+        }
+        path := strings.TrimSpace(strings.TrimPrefix(userInput, cmdImage))
+        image, err := loadImage(path)
         if err != nil {
-          fmt.Printf("Error formatting response: %v\n", err)
+          slog.Error("attaching image", "err", err)
+          blankLine(outputError)
+          continue
         }
-        fmt.Println();
-      }
-    } else {
-      scanner.Scan()
-      userInput := scanner.Text()
-
-      if strings.ToLower(userInput) == cmdQuit {
-        fmt.Println("Exiting interactive mode.")
-        fmt.Println()
-        return
-      }
-      if strings.ToLower(userInput) == cmdMulti {
-        fmt.Printf("Multiline mode. Type %s to finish input, %s to delete the most recent line.\n", cmdEnd, cmdRemove)
-        fmt.Println()
-        isMultiline = true
-        lines = nil
+        pendingImages = append(pendingImages, image)
+        fmt.Printf("Attached %s to your next message (%d image(s)).\n", path, len(pendingImages))
+        blankLine(outputCommand)
         continue
       }
       if strings.HasPrefix(userInput, cmdFile) {
-        fileName := strings.TrimPrefix(userInput, cmdFile)
-        content, err := readFile(fileName)
+        files, err := loadContextFiles(strings.TrimPrefix(userInput, cmdFile))
         if err != nil {
-          fmt.Printf("Error reading file: %v\n", err)
+          slog.Error("reading file", "err", err)
+          blankLine(outputError)
           continue
         }
-        contextFile = fileName
-        fileContext := fmt.Sprintf("Content of %s:\n%s", fileName, content)
-        messages = append(messages, openai.ChatCompletionMessage{
-          Role: openai.ChatMessageRoleUser,
-          Content: fileContext,
-        })
-        fmt.Printf("Added %s to the context.\n", fileName)
-        fmt.Println()
+        for _, file := range files {
+          addFileContext(file.name, file.content)
+        }
+        blankLine(outputCommand)
         continue
       }
 
       userMessage := userInput
-      if contextFile != "" {
-        userMessage = fmt.Sprintf("(Context: %s) %s", contextFile, userInput)
-      }
-
-      messages = append(messages, openai.ChatCompletionMessage{
-        Role: openai.ChatMessageRoleUser,
-        Content: userMessage,
-      })
-
-      response, err := callOpenAI(client, config, messages)
-      if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        continue
+      if len(contextFiles) > 0 {
+        userMessage = fmt.Sprintf("(Context: %s) %s", strings.Join(contextFiles, ", "), userInput)
       }
 
-      messages = append(messages, openai.ChatCompletionMessage{
-        Role: openai.ChatMessageRoleAssistant,
-        Content: response,
-      })
-
-      err = printFormattedResponse(response, config.Style, config.AIName, config.Model)
-      if err != nil {
-        fmt.Printf("Error formatting response: %v\n", err)
-      }
-      fmt.Println()
+      send(userMessage)
     }
   }
 }
 
-func formatInputPrefix(dir string, isMultiline bool, aiName string) string {
+func formatInputPrefix(dir string, isMultiline, stateless bool, contextMeter string) string {
 	dirStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("86"))
 	youStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("183")).Bold(true)
 	multilineStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("204")) 
+	meterStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+	statelessStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
 
 	formattedDir := dirStyle.Render(fmt.Sprintf("(%s)", dir))
 	formattedYou := youStyle.Render("You")
+
+	if contextMeter != "" {
+		formattedDir += " " + meterStyle.Render(fmt.Sprintf("[%s]", contextMeter))
+	}
+	if stateless {
+		formattedDir += " " + statelessStyle.Render("[Stateless]")
+	}
 	
 	if isMultiline {
 		multilineIndicator := multilineStyle.Render("[Multiline]")
@@ -254,6 +1954,240 @@ func formatInputPrefix(dir string, isMultiline bool, aiName string) string {
 	return fmt.Sprintf("%s %s: ", formattedDir, formattedYou)
 }
 
+// stringList is a flag that can be given more than once, collecting each
+// value.
+type stringList []string
+
+func (l *stringList) String() string {
+  return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+  *l = append(*l, value)
+  return nil
+}
+
+// Environment variables that override config values for a single run.
+const (
+  envTemperature =  "LLM_CLI_TEMPERATURE"
+  envMaxTokens =    "LLM_CLI_MAX_TOKENS"
+  envTopP =         "LLM_CLI_TOP_P"
+  envSystemPrompt = "LLM_CLI_SYSTEM_PROMPT"
+)
+
+// runOverrides holds the raw values of the flags that change settings for
+// this run. Unset strings and false booleans leave the config alone.
+type runOverrides struct {
+  persona          string
+  model            string
+  baseURL          string
+  stop             stringList
+  embeddingModel   string
+  responseFormat   string
+  maxRetries       *int
+  timeout          int
+  stateless        bool
+  stream           bool
+  showUsage        bool
+  plain            bool
+  wrap             string
+  noColor          bool
+  temperature      string
+  maxTokens        string
+  topP             string
+  presencePenalty  string
+  frequencyPenalty string
+  seed             string
+  systemPrompt     string
+}
+
+// applyOverrides layers environment variables and then flags over config,
+// giving the precedence flag > env > config. It runs at startup and again on
+// each profile :profile switches to, so the overrides outlast the switch.
+// Nothing is written back to the config file.
+func applyOverrides(config *Config, overrides runOverrides) error {
+  pick := func(flagValue, env string) string {
+    if flagValue != "" {
+      return flagValue
+    }
+    return os.Getenv(env)
+  }
+
+  if overrides.persona != "" {
+    prompt, err := lookupPersona(overrides.persona)
+    if err != nil {
+      return fmt.Errorf("-persona: %w", err)
+    }
+    config.SystemPrompt = prompt
+    config.SystemPrompts = nil
+  }
+  if overrides.model != "" {
+    config.Model = overrides.model
+    config.modelChosen = true
+  }
+  if overrides.baseURL != "" {
+    config.BaseURL = overrides.baseURL
+  }
+  if len(overrides.stop) > 0 {
+    config.Stop = overrides.stop
+  }
+  if overrides.embeddingModel != "" {
+    config.EmbeddingModel = overrides.embeddingModel
+  }
+  if overrides.responseFormat != "" {
+    if err := checkResponseFormat(overrides.responseFormat); err != nil {
+      return fmt.Errorf("-response-format: %w", err)
+    }
+    config.ResponseFormat = overrides.responseFormat
+  }
+  if overrides.maxRetries != nil {
+    config.MaxRetries = overrides.maxRetries
+  }
+  if overrides.timeout < 0 {
+    return fmt.Errorf("-timeout must not be negative, got %d", overrides.timeout)
+  }
+  if overrides.timeout > 0 {
+    config.TimeoutSeconds = overrides.timeout
+  }
+  if overrides.stateless {
+    config.Stateless = true
+  }
+  if overrides.stream {
+    config.Stream = true
+  }
+  if overrides.showUsage {
+    config.ShowUsage = true
+  }
+  if overrides.plain {
+    config.Plain = true
+  }
+  if overrides.wrap != "" {
+    wrap, err := parseWordWrap(overrides.wrap)
+    if err != nil {
+      return fmt.Errorf("-wrap: %w", err)
+    }
+    config.WordWrap = wrap
+  }
+  if overrides.noColor || os.Getenv("NO_COLOR") != "" {
+    config.NoColor = true
+  }
+
+  if value := pick(overrides.temperature, envTemperature); value != "" {
+    temperature, err := parseTemperature(value)
+    if err != nil {
+      return err
+    }
+    config.Temperature = &temperature
+  }
+
+  if value := pick(overrides.maxTokens, envMaxTokens); value != "" {
+    maxTokens, err := strconv.Atoi(value)
+    if err != nil || maxTokens <= 0 {
+      return fmt.Errorf("max tokens must be a positive integer, got %q", value)
+    }
+    config.MaxTokens = maxTokens
+  }
+
+  if value := pick(overrides.topP, envTopP); value != "" {
+    topP, err := parseTopP(value)
+    if err != nil {
+      return err
+    }
+    config.TopP = &topP
+  }
+
+  if overrides.presencePenalty != "" {
+    penalty, err := parsePenalty("presence_penalty", overrides.presencePenalty)
+    if err != nil {
+      return err
+    }
+    config.PresencePenalty = penalty
+  }
+  if overrides.frequencyPenalty != "" {
+    penalty, err := parsePenalty("frequency_penalty", overrides.frequencyPenalty)
+    if err != nil {
+      return err
+    }
+    config.FrequencyPenalty = penalty
+  }
+
+  if overrides.seed != "" {
+    seed, err := strconv.Atoi(overrides.seed)
+    if err != nil {
+      return fmt.Errorf("seed must be an integer, got %q", overrides.seed)
+    }
+    config.Seed = &seed
+  }
+
+  if value := pick(overrides.systemPrompt, envSystemPrompt); value != "" {
+    config.SystemPrompt = value
+    config.SystemPrompts = nil
+  }
+  config.overrides = &overrides
+  return nil
+}
+
+// formatMaxTokens shows a MaxTokens value, where 0 leaves the limit to the
+// API.
+func formatMaxTokens(maxTokens int) string {
+  if maxTokens == 0 {
+    return "off"
+  }
+  return strconv.Itoa(maxTokens)
+}
+
+// routeTurn applies Config.ModelRouting to a prompt, noting the choice when
+// a rule picked a model.
+func routeTurn(config Config, prompt string) string {
+  if config.modelChosen {
+    return config.Model
+  }
+  model, reason := routeModel(config.ModelRouting, prompt, config.Model)
+  if reason != "" {
+    slog.Info("routed prompt", "model", model, "reason", reason)
+  }
+  return model
+}
+
+// defaultCritiquePrompt asks for a second pass when -self-critique is set and
+// no CritiquePrompt is configured.
+const defaultCritiquePrompt = "Critique your previous answer: point out any mistakes, gaps or unclear parts, then reply with only an improved final answer."
+
+func critiquePrompt(config Config) string {
+  if config.CritiquePrompt != "" {
+    return config.CritiquePrompt
+  }
+  return defaultCritiquePrompt
+}
+
+// defaultDateTimeFormat is used when InjectDateTime is on and no
+// DateTimeFormat is configured.
+const defaultDateTimeFormat = "Monday, January 2, 2006 15:04 MST"
+
+// systemPrompt builds the effective system prompt for a new conversation.
+// SystemPrompt and then each of SystemPrompts are joined by blank lines, so
+// reusable fragments can be composed.
+func systemPrompt(config Config) string {
+  var parts []string
+  if config.SystemPrompt != "" {
+    parts = append(parts, config.SystemPrompt)
+  }
+  for _, part := range config.SystemPrompts {
+    if part != "" {
+      parts = append(parts, part)
+    }
+  }
+  prompt := strings.Join(parts, "\n\n")
+  if config.InjectDateTime {
+    layout := config.DateTimeFormat
+    if layout == "" {
+      layout = defaultDateTimeFormat
+    }
+    prompt = fmt.Sprintf("Current date and time: %s.\n\n%s", time.Now().Format(layout), prompt)
+  }
+  return prompt
+}
+
 func getCurrentDirectory() string {
   currentDir, err := os.Getwd()
   if err != nil {
@@ -268,39 +2202,455 @@ func getCurrentDirectory() string {
   return currentDir
 }
 
+// readFile reads a text file, refusing binary content, which would only
+// waste tokens.
 func readFile(fileName string) (string, error) {
   content, err := os.ReadFile(fileName)
   if err != nil {
     return "", err
   }
+  if looksBinary(content) {
+    return "", fmt.Errorf("%s appears to be binary; only text files can be added", fileName)
+  }
   return string(content), nil
 }
 
-func callOpenAI(client *openai.Client, config Config, messages []openai.ChatCompletionMessage) (string, error) {
-  resp, err := client.CreateChatCompletion(
-    context.Background(),
-    openai.ChatCompletionRequest{
-      Model: config.Model,
-      Messages: messages,
-    },
-  )
+// setSystemMessage replaces the leading system message, inserting one if
+// the history doesn't start with it.
+func setSystemMessage(messages []openai.ChatCompletionMessage, content string) []openai.ChatCompletionMessage {
+  system := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: content}
+  if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+    messages[0] = system
+    return messages
+  }
+  return append([]openai.ChatCompletionMessage{system}, messages...)
+}
+
+func lastAssistantMessage(messages []openai.ChatCompletionMessage) (string, bool) {
+  for i := len(messages) - 1; i >= 0; i-- {
+    if messages[i].Role == openai.ChatMessageRoleAssistant {
+      return messages[i].Content, true
+    }
+  }
+  return "", false
+}
+
+func dumpMessages(messages []openai.ChatCompletionMessage) (string, error) {
+  type dumpedMessage struct {
+    Role    string `json:"role"`
+    Content string `json:"content"`
+  }
+
+  dumped := make([]dumpedMessage, 0, len(messages))
+  for _, msg := range messages {
+    content := messageText(msg)
+    if runes := []rune(content); len(runes) > maxDumpContentLen {
+      content = fmt.Sprintf("%s... [truncated %d of %d chars]", string(runes[:maxDumpContentLen]), len(runes)-maxDumpContentLen, len(runes))
+    }
+    dumped = append(dumped, dumpedMessage{Role: msg.Role, Content: content})
+  }
 
+  out, err := marshalCanonicalJSON(dumped)
   if err != nil {
     return "", err
   }
+  return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// completion is a response and the tokens the API reported for it. Usage is
+// zero where the backend doesn't report it, as in assistant mode.
+type completion struct {
+  content     string
+  usage       openai.Usage
+  fingerprint string
+  // model is the model that answered, which is a fallback model when the
+  // configured one failed. It is empty for assistant mode.
+  model string
+}
+
+// answeredConfig returns config with Model set to the model that produced
+// result, for showing in the response header.
+func answeredConfig(config Config, result completion) Config {
+  if result.model != "" {
+    config.Model = result.model
+  }
+  return config
+}
+
+// callModel sends messages to config.Model, falling back through
+// config.FallbackModels if needed. With a response schema configured, a
+// non-conforming reply is retried up to config.SchemaRetries times with the
+// violations fed back to the model.
+func callModel(ctx context.Context, backend provider, config Config, messages []openai.ChatCompletionMessage) (completion, error) {
+  result, err := requestCompletion(ctx, backend, config, messages)
+  if err != nil || config.responseSchema == nil {
+    return result, err
+  }
+
+  for attempt := 1; ; attempt++ {
+    violations, err := validateResponse(config.responseSchema, result.content)
+    if err != nil {
+      return completion{}, err
+    }
+    if len(violations) == 0 {
+      return result, nil
+    }
+    if attempt > config.SchemaRetries {
+      return completion{}, schemaViolationError(violations)
+    }
+
+    slog.Warn("response did not match the schema, retrying", "attempt", attempt, "of", config.SchemaRetries)
+    messages = append(messages[:len(messages):len(messages)],
+      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: result.content},
+      openai.ChatCompletionMessage{
+        Role: openai.ChatMessageRoleUser,
+        Content: fmt.Sprintf("Your response did not match the required JSON schema:\n- %s\nRespond again with corrected JSON only.", strings.Join(violations, "\n- ")),
+      },
+    )
+    retry, err := requestCompletion(ctx, backend, config, messages)
+    if err != nil {
+      return completion{}, err
+    }
+    // Every attempt is billed, so the usage covers them all.
+    retry.usage = addUsage(result.usage, retry.usage)
+    result = retry
+  }
+}
+
+func requestCompletion(ctx context.Context, backend provider, config Config, messages []openai.ChatCompletionMessage) (completion, error) {
+  var result completion
+  model, err := withFallback(config, func(model string) error {
+    modelConfig := config
+    modelConfig.Model = model
+    return withRetry(ctx, config, func() error {
+      requestCtx, cancel := withRequestTimeout(ctx, config)
+      defer cancel()
+      var err error
+      result, err = backend.complete(requestCtx, messages, modelConfig)
+      return describeTimeout(ctx, config, err)
+    })
+  })
+  if err == nil {
+    result.model = model
+    logFingerprint(config, result.fingerprint)
+  }
+  return result, err
+}
+
+// logFingerprint notes the backend configuration that produced a seeded
+// response. Responses for the same seed are only expected to match while the
+// fingerprint stays the same.
+func logFingerprint(config Config, fingerprint string) {
+  if config.Seed != nil && fingerprint != "" {
+    slog.Info("seeded response", "seed", *config.Seed, "system_fingerprint", fingerprint)
+  }
+}
+
+func newChatRequest(config Config, model string, messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+  request := openai.ChatCompletionRequest{
+    Model: model,
+    Messages: messages,
+    Temperature: requestSampling(config.Temperature),
+    TopP: requestSampling(config.TopP),
+    MaxTokens: config.MaxTokens,
+    Stop: config.Stop,
+    PresencePenalty: config.PresencePenalty,
+    FrequencyPenalty: config.FrequencyPenalty,
+    Seed: config.Seed,
+  }
+  if config.responseSchema != nil {
+    request.ResponseFormat = responseFormatForSchema(config.ResponseSchema, config.responseSchema)
+  } else if config.ResponseFormat == responseFormatJSON {
+    request.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+  }
+  return request
+}
+
+// withFallback calls call with config.Model. If that model is unavailable or
+// rate limited, each of config.FallbackModels is tried in turn. It returns
+// the model whose call succeeded.
+func withFallback(config Config, call func(model string) error) (string, error) {
+  models := append([]string{config.Model}, config.FallbackModels...)
+
+  var errs []error
+  for i, model := range models {
+    if i > 0 {
+      slog.Warn("model failed, falling back", "model", models[i-1], "fallback", model)
+    }
+
+    err := call(model)
+    if err == nil {
+      return model, nil
+    }
+
+    if len(models) == 1 {
+      return "", err
+    }
+    errs = append(errs, fmt.Errorf("%s: %w", model, err))
+    if !shouldFallback(err) {
+      break
+    }
+  }
+
+  return "", errors.Join(errs...)
+}
+
+func parseTemperature(value string) (float32, error) {
+  temperature, err := strconv.ParseFloat(value, 32)
+  if err != nil {
+    return 0, fmt.Errorf("invalid temperature %q", value)
+  }
+  if temperature < 0 || temperature > 2 {
+    return 0, fmt.Errorf("temperature must be between 0 and 2, got %s", value)
+  }
+  return float32(temperature), nil
+}
+
+func parseTopP(value string) (float32, error) {
+  topP, err := strconv.ParseFloat(value, 32)
+  if err != nil {
+    return 0, fmt.Errorf("invalid top_p %q", value)
+  }
+  if topP < 0 || topP > 1 {
+    return 0, fmt.Errorf("top_p must be between 0 and 1, got %s", value)
+  }
+  return float32(topP), nil
+}
+
+// parsePenalty parses a presence or frequency penalty. Both default to 0,
+// which is also what the API assumes when the field is left out.
+func parsePenalty(name, value string) (float32, error) {
+  penalty, err := strconv.ParseFloat(value, 32)
+  if err != nil {
+    return 0, fmt.Errorf("invalid %s %q", name, value)
+  }
+  if penalty < -2 || penalty > 2 {
+    return 0, fmt.Errorf("%s must be between -2 and 2, got %s", name, value)
+  }
+  return float32(penalty), nil
+}
+
+func formatTemperature(temperature *float32) string {
+  if temperature == nil {
+    return "default"
+  }
+  return strconv.FormatFloat(float64(*temperature), 'f', -1, 32)
+}
+
+// requestSampling converts a configured temperature or top_p to the request
+// field. The client omits zero values, so an explicit 0 is sent as the
+// smallest non-zero float instead of silently falling back to the default.
+func requestSampling(value *float32) float32 {
+  if value == nil {
+    return 0
+  }
+  if *value == 0 {
+    return math.SmallestNonzeroFloat32
+  }
+  return *value
+}
+
+// shouldFallback reports whether err means another model might succeed
+// where this one did not.
+func shouldFallback(err error) bool {
+  switch httpStatusCode(err) {
+  case http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+    return true
+  }
+  return false
+}
 
-  return resp.Choices[0].Message.Content, nil
+func httpStatusCode(err error) int {
+  var apiErr *openai.APIError
+  if errors.As(err, &apiErr) {
+    return apiErr.HTTPStatusCode
+  }
+  var reqErr *openai.RequestError
+  if errors.As(err, &reqErr) {
+    return reqErr.HTTPStatusCode
+  }
+  var anthropicErr *anthropicError
+  if errors.As(err, &anthropicErr) {
+    return anthropicErr.HTTPStatusCode
+  }
+  return 0
 }
 
-func printFormattedResponse(response, style, aiName, model string) error {
-	r, err := glamour.NewTermRenderer(
-		glamour.WithStylePath(fmt.Sprintf("./styles/%s.json", style)),
-		glamour.WithWordWrap(100),
-	)
+func printFormattedResponse(response string, config Config) error {
+	// Markdown rendering would reflow and restyle JSON, so it is printed as
+	// the model wrote it.
+	if plainOutput(config) || config.ResponseFormat == responseFormatJSON {
+		fmt.Println(strings.TrimRight(response, "\n"))
+		return nil
+	}
+
+	if config.AutoFormatJSON {
+		if pretty, ok := prettyJSON(response); ok {
+			// A fenced block gets the style's syntax highlighting.
+			response = "```json\n" + pretty + "\n```"
+		}
+	}
+
+	out, err := renderMarkdown(response, config)
 	if err != nil {
 		return err
 	}
 
+	if config.AltScreen {
+		if height, ok := altScreenHeight(out); ok {
+			if err := viewInAltScreen(strings.Split(strings.TrimRight(out, "\n"), "\n"), height); err != nil {
+				return err
+			}
+			// Keep the main scrollback clean: only note what was shown.
+			printResponseHeader(config.AIName, config.Model)
+			fmt.Println(dimStyle.Render(fmt.Sprintf("(%d lines shown in the alternate screen; %s prints the source)", strings.Count(out, "\n"), cmdRaw)))
+			return nil
+		}
+	}
+
+	printResponseHeader(config.AIName, config.Model)
+	fmt.Print(out)
+	return nil
+}
+
+// prettyJSON indents response if it is a JSON object or array.
+func prettyJSON(response string) (string, bool) {
+	trimmed := strings.TrimSpace(response)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return "", false
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(trimmed), "", "  "); err != nil {
+		return "", false
+	}
+	return out.String(), true
+}
+
+func renderMarkdown(markdown string, config Config) (string, error) {
+	r, err := newTermRenderer(config)
+	if err != nil {
+		return "", err
+	}
+	// Citation links are escape codes too, so NoColor leaves them out.
+	if !config.RenderCitations || config.NoColor {
+		return r.Render(markdown)
+	}
+
+	markdown, sources := citationSources(markdown)
+	out, err := r.Render(markdown)
+	if err != nil || len(sources) == 0 {
+		return out, err
+	}
+	return styleCitations(out, sources), nil
+}
+
+func newTermRenderer(config Config) (*glamour.TermRenderer, error) {
+	styleOption, err := rendererStyle(config)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []glamour.TermRendererOption{
+		styleOption,
+		glamour.WithWordWrap(wordWrap(config)),
+	}
+	if config.PreserveNewlines {
+		options = append(options, glamour.WithPreservedNewLines())
+	}
+	return glamour.NewTermRenderer(options...)
+}
+
+// rendererStyle returns the configured style, or glamour's ASCII style,
+// which emits no escape codes, with NoColor. A built-in style name wins over
+// a file of the same name.
+func rendererStyle(config Config) (glamour.TermRendererOption, error) {
+	if config.NoColor {
+		styles := glamour.ASCIIStyleConfig
+		if config.Margin != nil {
+			styles.Document.Margin = config.Margin
+		}
+		return glamour.WithStyles(styles), nil
+	}
+
+	if styles, ok := builtinStyle(config.Style); ok {
+		if config.Margin == nil {
+			return glamour.WithStandardStyle(config.Style), nil
+		}
+		styles.Document.Margin = config.Margin
+		return glamour.WithStyles(styles), nil
+	}
+
+	stylePath, err := findConfigFile(filepath.Join("styles", config.Style+".json"))
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("using style", "path", stylePath)
+
+	// Overriding the margin means loading the style ourselves so the
+	// document block can be adjusted before it reaches glamour.
+	if config.Margin == nil {
+		return glamour.WithStylePath(stylePath), nil
+	}
+	jsonBytes, err := os.ReadFile(stylePath)
+	if err != nil {
+		return nil, err
+	}
+	var styles ansi.StyleConfig
+	if err := json.Unmarshal(jsonBytes, &styles); err != nil {
+		return nil, fmt.Errorf("parsing style %s: %w", stylePath, err)
+	}
+	styles.Document.Margin = config.Margin
+	return glamour.WithStyles(styles), nil
+}
+
+// defaultWordWrap is the render width when the terminal width is unknown,
+// such as when stdout isn't a terminal.
+const defaultWordWrap = 100
+
+// wordWrap returns the render width: Config.WordWrap when positive, and
+// otherwise ("auto") the terminal width or defaultWordWrap. It is checked on
+// every render, so resizing the terminal takes effect on the next response.
+func wordWrap(config Config) int {
+	if config.WordWrap > 0 {
+		return config.WordWrap
+	}
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+	return defaultWordWrap
+}
+
+// parseWordWrap reads a wrap setting: a positive width, or "auto", which is
+// stored as 0.
+func parseWordWrap(value string) (int, error) {
+	if strings.EqualFold(value, "auto") {
+		return 0, nil
+	}
+	width, err := strconv.Atoi(value)
+	if err != nil || width <= 0 {
+		return 0, fmt.Errorf("wrap width must be a positive integer or auto, got %q", value)
+	}
+	return width, nil
+}
+
+func formatWordWrap(config Config) string {
+	if config.WordWrap <= 0 {
+		return fmt.Sprintf("auto (%d)", wordWrap(config))
+	}
+	return strconv.Itoa(wordWrap(config))
+}
+
+// dimStyle is used for secondary notes printed around responses.
+var dimStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+
+// plainOutput reports whether responses are printed as raw text, without
+// markdown rendering or the response header: with Config.Plain, or when
+// stdout isn't a terminal.
+func plainOutput(config Config) bool {
+	return config.Plain || !term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+func printResponseHeader(aiName, model string) {
 	aiNameStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("39")).Bold(true)
 	modelStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("178"))
 
@@ -308,12 +2658,4 @@ func printFormattedResponse(response, style, aiName, model string) error {
 	formattedModel := modelStyle.Render(fmt.Sprintf("(%s)", model))
 
   fmt.Printf("\n%s %s: ", formattedAIName, formattedModel)
-
-	out, err := r.Render(response)
-	if err != nil {
-		return err
-	}
-
-	fmt.Print(out)
-	return nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "sort"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// moderationError is returned for input the moderation endpoint flagged.
+type moderationError struct {
+  categories []string
+}
+
+func (e *moderationError) Error() string {
+  if len(e.categories) == 0 {
+    return "input was flagged by moderation"
+  }
+  return "input was flagged by moderation: " + strings.Join(e.categories, ", ")
+}
+
+// moderateInput checks text with the moderation endpoint when
+// config.Moderate is set, returning a *moderationError if it is flagged.
+// With moderation off it does nothing.
+func moderateInput(ctx context.Context, backend provider, config Config, text string) error {
+  if !config.Moderate {
+    return nil
+  }
+  client := openAIClient(backend)
+  if client == nil {
+    return fmt.Errorf("moderation needs the %s provider", providerOpenAI)
+  }
+
+  var resp openai.ModerationResponse
+  err := withRetry(ctx, config, func() error {
+    requestCtx, cancel := withRequestTimeout(ctx, config)
+    defer cancel()
+    var err error
+    resp, err = client.Moderations(requestCtx, openai.ModerationRequest{Input: text})
+    return describeTimeout(ctx, config, err)
+  })
+  if err != nil {
+    return fmt.Errorf("moderation check: %w", err)
+  }
+  for _, result := range resp.Results {
+    if result.Flagged {
+      return &moderationError{categories: flaggedCategories(result.Categories)}
+    }
+  }
+  return nil
+}
+
+// flaggedCategories lists the categories set in categories by their API
+// names, such as "harassment" or "self-harm/intent", sorted.
+func flaggedCategories(categories openai.ResultCategories) []string {
+  raw, err := json.Marshal(categories)
+  if err != nil {
+    return nil
+  }
+  var flags map[string]bool
+  if err := json.Unmarshal(raw, &flags); err != nil {
+    return nil
+  }
+  var names []string
+  for name, flagged := range flags {
+    if flagged {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+  return names
+}
+
+// isModerationError reports whether err is input being flagged, as opposed
+// to the check itself failing.
+func isModerationError(err error) bool {
+  var modErr *moderationError
+  return errors.As(err, &modErr)
+}
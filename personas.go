@@ -0,0 +1,102 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// builtinPersonas are curated system prompts selectable by name with
+// -persona or :persona. Files in the user's personas directory are added to
+// these and take precedence on a name clash.
+var builtinPersonas = map[string]string{
+  "code-reviewer": "You are a meticulous senior code reviewer. Point out bugs, security issues, unclear naming and missing tests first, then style nits. Quote the relevant lines and suggest concrete fixes.",
+  "sql-expert":    "You are an expert in SQL and relational database design. Write correct, readable queries, explain query plans and indexing trade-offs, and mention dialect differences when they matter.",
+  "editor":        "You are a careful writing editor. Improve clarity, concision and flow while keeping the author's voice. Show the revised text, then briefly list the main changes.",
+  "explainer":     "You explain technical topics to newcomers. Start with an intuitive summary, use a simple analogy, then add detail step by step. Avoid unexplained jargon.",
+  "shell":         "You are a Unix shell expert. Answer with the command first in a code block, followed by a short explanation of each flag. Prefer portable POSIX tools and warn about destructive commands.",
+  "architect":     "You are a pragmatic software architect. Discuss trade-offs, failure modes and operational cost, and recommend the simplest design that meets the stated requirements.",
+}
+
+// personasDir is where users can add their own personas, one file per
+// persona whose name is the file name without its extension.
+func personasDir() (string, error) {
+  dir, err := userConfigDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(dir, "personas"), nil
+}
+
+// loadPersonas returns the built-in personas merged with any found in the
+// user's personas directory, and the set of names that came from files.
+func loadPersonas() (map[string]string, map[string]bool, error) {
+  personas := make(map[string]string, len(builtinPersonas))
+  for name, prompt := range builtinPersonas {
+    personas[name] = prompt
+  }
+  custom := map[string]bool{}
+
+  dir, err := personasDir()
+  if err != nil {
+    return personas, custom, nil
+  }
+  entries, err := os.ReadDir(dir)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return personas, custom, nil
+    }
+    return nil, nil, fmt.Errorf("reading personas directory: %w", err)
+  }
+
+  for _, entry := range entries {
+    if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+      continue
+    }
+    content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+    if err != nil {
+      return nil, nil, fmt.Errorf("reading persona %s: %w", entry.Name(), err)
+    }
+    name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+    personas[name] = strings.TrimSpace(string(content))
+    custom[name] = true
+  }
+  return personas, custom, nil
+}
+
+func lookupPersona(name string) (string, error) {
+  personas, _, err := loadPersonas()
+  if err != nil {
+    return "", err
+  }
+  prompt, ok := personas[name]
+  if !ok {
+    return "", fmt.Errorf("unknown persona %q (see :personas)", name)
+  }
+  return prompt, nil
+}
+
+func formatPersonaList() (string, error) {
+  personas, custom, err := loadPersonas()
+  if err != nil {
+    return "", err
+  }
+
+  names := make([]string, 0, len(personas))
+  for name := range personas {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  var out strings.Builder
+  for _, name := range names {
+    label := name
+    if custom[name] {
+      label += " (custom)"
+    }
+    fmt.Fprintf(&out, "  %-24s %s\n", label, dimStyle.Render(truncateValue(personas[name])))
+  }
+  return strings.TrimSuffix(out.String(), "\n"), nil
+}
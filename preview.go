@@ -0,0 +1,54 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// formatPreview summarises what the next request will carry: the model, the
+// system prompt, the files added with :file, the images attached with :image
+// and the estimated size of the history.
+func formatPreview(config Config, messages []openai.ChatCompletionMessage, files map[string]string, images []openai.ChatMessagePart) string {
+  var out strings.Builder
+  fmt.Fprintf(&out, "Model: %s\n", config.Model)
+
+  system := "(none)"
+  if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem && messages[0].Content != "" {
+    system = messages[0].Content
+  }
+  fmt.Fprintf(&out, "System prompt:\n%s\n", indentLines(system, "  "))
+
+  names := make([]string, 0, len(files))
+  for name := range files {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  fmt.Fprintf(&out, "Files: %d\n", len(names))
+  for _, name := range names {
+    fmt.Fprintf(&out, "  %s (%s)\n", name, formatByteSize(len(files[name])))
+  }
+  fmt.Fprintf(&out, "Images: %d\n", len(images))
+  for i, image := range images {
+    fmt.Fprintf(&out, "  %d. %s\n", i+1, describeImage(image))
+  }
+
+  fmt.Fprintf(&out, "Messages: %d, about %s tokens", len(messages), formatContextMeter(messages, config.Model))
+  return out.String()
+}
+
+func indentLines(text, prefix string) string {
+  return prefix + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n"+prefix)
+}
+
+func formatByteSize(n int) string {
+  switch {
+  case n < 1024:
+    return fmt.Sprintf("%d B", n)
+  case n < 1024*1024:
+    return fmt.Sprintf("%.1f KB", float64(n)/1024)
+  }
+  return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+)
+
+// modelPrices maps model name prefixes to list prices in USD per million
+// input and output tokens. Longer prefixes are listed first so "gpt-4o-mini"
+// wins over "gpt-4o". Prices change; treat costs derived from them as
+// estimates.
+var modelPrices = []struct {
+  prefix string
+  input  float64
+  output float64
+}{
+  {"gpt-4o-mini", 0.15, 0.60},
+  {"gpt-4o", 2.50, 10.00},
+  {"gpt-4-turbo", 10.00, 30.00},
+  {"gpt-4-32k", 60.00, 120.00},
+  {"gpt-4", 30.00, 60.00},
+  {"gpt-3.5-turbo", 0.50, 1.50},
+  {"o1-mini", 3.00, 12.00},
+  {"o1", 15.00, 60.00},
+}
+
+// estimateCost returns the cost in USD of a request to model, and false if
+// the model's price is unknown.
+func estimateCost(model string, inputTokens, outputTokens int) (float64, bool) {
+  for _, p := range modelPrices {
+    if strings.HasPrefix(model, p.prefix) {
+      return (float64(inputTokens)*p.input + float64(outputTokens)*p.output) / 1e6, true
+    }
+  }
+  return 0, false
+}
+
+func formatCost(cost float64) string {
+  if cost < 0.01 {
+    return fmt.Sprintf("$%.4f", cost)
+  }
+  return fmt.Sprintf("$%.2f", cost)
+}
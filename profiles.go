@@ -0,0 +1,108 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "slices"
+  "strings"
+)
+
+// configProfiles are named sets of settings in config.json, each laid over
+// the top-level settings when selected with -profile or :profile.
+type configProfiles map[string]json.RawMessage
+
+// applyProfile returns base with the settings of the named entry in
+// base.Profiles laid over it. Settings the profile leaves out keep their
+// values from base. The result is validated as a whole, so only the
+// combination needs a model and style.
+func applyProfile(base Config, name string) (Config, error) {
+  raw, ok := base.Profiles[name]
+  if !ok {
+    if len(base.Profiles) == 0 {
+      return base, fmt.Errorf("no profile named %q; the config has no profiles", name)
+    }
+    return base, fmt.Errorf("no profile named %q; available: %s", name, strings.Join(profileNames(base), ", "))
+  }
+
+  var keys map[string]json.RawMessage
+  if err := json.Unmarshal(raw, &keys); err != nil {
+    return base, fmt.Errorf("profile %s: %w", name, describeDecodeError(err))
+  }
+  if _, nested := keys["profiles"]; nested {
+    return base, fmt.Errorf("profile %s: profiles can't contain other profiles", name)
+  }
+
+  // Decoding into a fresh copy keeps maps such as hooks from being shared
+  // with, and changed in, base.
+  encoded, err := json.Marshal(base)
+  if err != nil {
+    return base, err
+  }
+  var config Config
+  if err := json.Unmarshal(encoded, &config); err != nil {
+    return base, err
+  }
+  decoder := json.NewDecoder(bytes.NewReader(raw))
+  decoder.DisallowUnknownFields()
+  if err := decoder.Decode(&config); err != nil {
+    return base, fmt.Errorf("profile %s: %w", name, describeDecodeError(err))
+  }
+  if err := validateConfig(config); err != nil {
+    return base, fmt.Errorf("profile %s: %w", name, err)
+  }
+  config.profile = name
+  config.unprofiled = &base
+  return config, nil
+}
+
+// switchProfile returns the config :profile switches to: the config file's
+// settings with the named profile laid over them, then the flags and
+// environment variables current was started with.
+func switchProfile(current Config, name string) (Config, error) {
+  next, err := applyProfile(*current.unprofiled, name)
+  if err != nil {
+    return current, err
+  }
+  if next.ResponseSchema != "" {
+    next.responseSchema, err = loadResponseSchema(next.ResponseSchema)
+    if err != nil {
+      return current, err
+    }
+  }
+  if current.overrides != nil {
+    if err := applyOverrides(&next, *current.overrides); err != nil {
+      return current, err
+    }
+  }
+  return next, nil
+}
+
+// profileNames lists the profiles in config, sorted.
+func profileNames(config Config) []string {
+  names := make([]string, 0, len(config.Profiles))
+  for name := range config.Profiles {
+    names = append(names, name)
+  }
+  slices.Sort(names)
+  return names
+}
+
+// formatProfileList shows the profiles for :profile, marking the active one.
+func formatProfileList(config Config) string {
+  if len(config.Profiles) == 0 {
+    return `No profiles. Add them under "profiles" in config.json.`
+  }
+  var out strings.Builder
+  for i, name := range profileNames(config) {
+    if i > 0 {
+      out.WriteByte('\n')
+    }
+    if name == config.profile {
+      fmt.Fprintf(&out, "* %s", name)
+    } else {
+      fmt.Fprintf(&out, "  %s", name)
+    }
+  }
+  return out.String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+)
+
+// newProfileTestConfig returns a config as loadConfig would, with a "work"
+// profile that sets its own model and turns colors back on.
+func newProfileTestConfig(t *testing.T) Config {
+  t.Helper()
+  base := Config{
+    Model: "gpt-4o-mini",
+    Style: "dark",
+    Profiles: configProfiles{
+      "work": json.RawMessage(`{"model": "gpt-4o", "no_color": false, "temperature": 0.2}`),
+    },
+  }
+  unprofiled := base
+  base.unprofiled = &unprofiled
+  return base
+}
+
+func TestSwitchProfileKeepsOverrides(t *testing.T) {
+  t.Setenv("NO_COLOR", "1")
+  config := newProfileTestConfig(t)
+  if err := applyOverrides(&config, runOverrides{model: "my-model", plain: true}); err != nil {
+    t.Fatal(err)
+  }
+
+  next, err := switchProfile(config, "work")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if next.profile != "work" {
+    t.Errorf("profile = %q, want work", next.profile)
+  }
+  if next.Model != "my-model" || !next.modelChosen {
+    t.Errorf("model = %q (chosen %v), want my-model from -m", next.Model, next.modelChosen)
+  }
+  if !next.NoColor {
+    t.Error("NO_COLOR was dropped by the profile switch")
+  }
+  if !next.Plain {
+    t.Error("-plain was dropped by the profile switch")
+  }
+  if next.Temperature == nil || *next.Temperature != 0.2 {
+    t.Errorf("temperature = %v, want the profile's 0.2", next.Temperature)
+  }
+
+  // Switching again starts from the file's settings, not the last profile.
+  again, err := switchProfile(next, "work")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if again.Model != "my-model" || !again.NoColor {
+    t.Errorf("second switch: model %q, no_color %v", again.Model, again.NoColor)
+  }
+}
+
+func TestSwitchProfileWithoutOverrides(t *testing.T) {
+  t.Setenv("NO_COLOR", "")
+  config := newProfileTestConfig(t)
+  if err := applyOverrides(&config, runOverrides{}); err != nil {
+    t.Fatal(err)
+  }
+
+  next, err := switchProfile(config, "work")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if next.Model != "gpt-4o" || next.modelChosen {
+    t.Errorf("model = %q (chosen %v), want the profile's gpt-4o", next.Model, next.modelChosen)
+  }
+  if next.NoColor {
+    t.Error("no_color set without NO_COLOR or -no-color")
+  }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "os"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// Values for Config.Provider, which selects the API requests are sent to.
+const (
+  providerOpenAI    = "openai"
+  providerAnthropic = "anthropic"
+)
+
+// provider sends a conversation to the model named by config.Model and
+// returns the reply. Retries and fallback models are handled around it, so
+// an implementation makes a single request.
+type provider interface {
+  complete(ctx context.Context, messages []openai.ChatCompletionMessage, config Config) (completion, error)
+}
+
+// newProvider builds the backend for config.Provider, reading its API key
+// from the environment.
+func newProvider(config Config) (provider, error) {
+  switch config.Provider {
+  case "", providerOpenAI:
+    if config.AzureEndpoint != "" {
+      return newAzureProvider(config)
+    }
+    // Local OpenAI-compatible servers usually don't check the key, so it is
+    // only required for the default endpoint.
+    apiKey := os.Getenv("OPENAI_API_KEY")
+    if apiKey == "" && config.BaseURL == "" {
+      return nil, errors.New("OPENAI_API_KEY not found in env")
+    }
+    clientConfig := openai.DefaultConfig(apiKey)
+    if config.BaseURL != "" {
+      clientConfig.BaseURL = config.BaseURL
+    }
+    // Requests are billed to the organization sent in the
+    // OpenAI-Organization header, or the account's default without one.
+    clientConfig.OrgID = config.OrgID
+    if orgID := os.Getenv("OPENAI_ORG_ID"); orgID != "" {
+      clientConfig.OrgID = orgID
+    }
+    return openAIProvider{client: openai.NewClientWithConfig(clientConfig)}, nil
+  case providerAnthropic:
+    apiKey := os.Getenv("ANTHROPIC_API_KEY")
+    if apiKey == "" && config.BaseURL == "" {
+      return nil, errors.New("ANTHROPIC_API_KEY not found in env")
+    }
+    return newAnthropicProvider(apiKey, config.BaseURL), nil
+  }
+  return nil, fmt.Errorf("unknown provider %q, expected %s or %s", config.Provider, providerOpenAI, providerAnthropic)
+}
+
+// newAzureProvider builds an OpenAI provider for an Azure OpenAI resource at
+// config.AzureEndpoint. Requests go to config.AzureDeployment whatever the
+// model; without one, the model name is used as the deployment name. The key
+// is read from AZURE_OPENAI_API_KEY, or OPENAI_API_KEY.
+func newAzureProvider(config Config) (provider, error) {
+  apiKey := os.Getenv("AZURE_OPENAI_API_KEY")
+  if apiKey == "" {
+    apiKey = os.Getenv("OPENAI_API_KEY")
+  }
+  if apiKey == "" {
+    return nil, errors.New("AZURE_OPENAI_API_KEY not found in env")
+  }
+  clientConfig := openai.DefaultAzureConfig(apiKey, config.AzureEndpoint)
+  if config.AzureDeployment != "" {
+    clientConfig.AzureModelMapperFunc = func(string) string {
+      return config.AzureDeployment
+    }
+  }
+  return openAIProvider{client: openai.NewClientWithConfig(clientConfig)}, nil
+}
+
+// openAIProvider sends chat completions through the OpenAI client.
+type openAIProvider struct {
+  client *openai.Client
+}
+
+func (p openAIProvider) complete(ctx context.Context, messages []openai.ChatCompletionMessage, config Config) (completion, error) {
+  resp, err := p.client.CreateChatCompletion(ctx, newChatRequest(config, config.Model, messages))
+  if err != nil {
+    return completion{}, err
+  }
+  if len(resp.Choices) == 0 {
+    return completion{}, errors.New("the API returned no choices")
+  }
+  return completion{content: resp.Choices[0].Message.Content, usage: resp.Usage, fingerprint: resp.SystemFingerprint}, nil
+}
+
+// openAIClient returns the client behind backend, or nil when it isn't the
+// OpenAI provider. Streaming and assistant mode use the client directly.
+func openAIClient(backend provider) *openai.Client {
+  if p, ok := backend.(openAIProvider); ok {
+    return p.client
+  }
+  return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "log/slog"
+  "math/rand/v2"
+  "net"
+  "net/http"
+  "time"
+)
+
+// Retry defaults and backoff bounds. The delay before retry n is
+// retryBaseDelay*2^n, capped at retryMaxDelay, with up to half of it
+// replaced by jitter.
+const (
+  defaultMaxRetries = 2
+  retryBaseDelay    = 500 * time.Millisecond
+  retryMaxDelay     = 8 * time.Second
+)
+
+// maxRetries returns Config.MaxRetries, or defaultMaxRetries when unset.
+func maxRetries(config Config) int {
+  if config.MaxRetries == nil {
+    return defaultMaxRetries
+  }
+  return *config.MaxRetries
+}
+
+// withRetry calls call and, while it fails with a transient error, retries
+// it with exponential backoff up to maxRetries(config) more times. Waiting
+// stops as soon as ctx is done.
+func withRetry(ctx context.Context, config Config, call func() error) error {
+  retries := maxRetries(config)
+  for attempt := 0; ; attempt++ {
+    err := call()
+    if err == nil || attempt >= retries || !isTransient(err) || ctx.Err() != nil {
+      return err
+    }
+
+    delay := retryDelay(attempt)
+    slog.Warn("request failed, retrying", "attempt", attempt+1, "max_retries", retries, "delay", delay.Round(time.Millisecond), "err", err)
+    timer := time.NewTimer(delay)
+    select {
+    case <-ctx.Done():
+      timer.Stop()
+      return ctx.Err()
+    case <-timer.C:
+    }
+  }
+}
+
+// withRequestTimeout bounds a single request by Config.TimeoutSeconds. With
+// no timeout set, ctx is returned unchanged. Cancelling ctx, as Ctrl-C does,
+// still ends the request early.
+func withRequestTimeout(ctx context.Context, config Config) (context.Context, context.CancelFunc) {
+  if config.TimeoutSeconds <= 0 {
+    return context.WithCancel(ctx)
+  }
+  return context.WithTimeout(ctx, time.Duration(config.TimeoutSeconds)*time.Second)
+}
+
+// requestTimeoutError is a request ended by Config.TimeoutSeconds.
+type requestTimeoutError struct {
+  seconds int
+  err     error
+}
+
+func (e *requestTimeoutError) Error() string {
+  return fmt.Sprintf("request timed out after %ds: %v", e.seconds, e.err)
+}
+
+func (e *requestTimeoutError) Unwrap() error { return e.err }
+
+// describeTimeout names the configured timeout in err when it is what ended
+// a request made under parent. The timeout bounds the whole wait, so the
+// result is not retried.
+func describeTimeout(parent context.Context, config Config, err error) error {
+  if err != nil && config.TimeoutSeconds > 0 && parent.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+    return &requestTimeoutError{seconds: config.TimeoutSeconds, err: err}
+  }
+  return err
+}
+
+func retryDelay(attempt int) time.Duration {
+  delay := min(retryBaseDelay<<attempt, retryMaxDelay)
+  half := delay / 2
+  return half + rand.N(half+1)
+}
+
+// isTransient reports whether err is worth retrying: rate limiting, a server
+// error or a network timeout. Client errors such as bad auth are not, and
+// neither is running over --timeout.
+func isTransient(err error) bool {
+  var timeoutErr *requestTimeoutError
+  if errors.Is(err, context.Canceled) || errors.As(err, &timeoutErr) {
+    return false
+  }
+  if code := httpStatusCode(err); code != 0 {
+    return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+  }
+  var netErr net.Error
+  return errors.As(err, &netErr) && netErr.Timeout()
+}
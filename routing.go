@@ -0,0 +1,95 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+)
+
+// routingRule picks Model for prompts matching every condition it sets.
+// Rules are checked in order and the first match wins; if none match, the
+// configured model is used.
+type routingRule struct {
+  Model    string   `json:"model"`
+  MinChars int      `json:"min_chars,omitempty"`
+  MaxChars int      `json:"max_chars,omitempty"`
+  Keywords []string `json:"keywords,omitempty"`
+  HasCode  *bool    `json:"has_code,omitempty"`
+}
+
+// checkRoutingRules reports rules that can't route anything as written: one
+// without a model, or with conditions no prompt can meet. Keywords are plain
+// substrings, so the only bad keyword is a blank one, which would match
+// every prompt.
+func checkRoutingRules(rules []routingRule) error {
+  for i, rule := range rules {
+    if strings.TrimSpace(rule.Model) == "" {
+      return fmt.Errorf(`rule %d has no "model"`, i+1)
+    }
+    if rule.MinChars < 0 || rule.MaxChars < 0 {
+      return fmt.Errorf(`rule %d: "min_chars" and "max_chars" must not be negative`, i+1)
+    }
+    if rule.MaxChars > 0 && rule.MinChars > rule.MaxChars {
+      return fmt.Errorf(`rule %d: "min_chars" %d is over "max_chars" %d, so it never matches`, i+1, rule.MinChars, rule.MaxChars)
+    }
+    for _, keyword := range rule.Keywords {
+      if strings.TrimSpace(keyword) == "" {
+        return fmt.Errorf(`rule %d has a blank keyword, which would match every prompt`, i+1)
+      }
+    }
+  }
+  return nil
+}
+
+// routeModel returns the model for prompt and a short explanation of which
+// conditions selected it. The reason is empty when no rule matched.
+func routeModel(rules []routingRule, prompt, defaultModel string) (string, string) {
+  length := len([]rune(prompt))
+  hasCode := strings.Contains(prompt, "```")
+  lower := strings.ToLower(prompt)
+
+  for _, rule := range rules {
+    var reasons []string
+
+    if rule.MinChars > 0 {
+      if length < rule.MinChars {
+        continue
+      }
+      reasons = append(reasons, fmt.Sprintf("prompt is %d chars, at least %d", length, rule.MinChars))
+    }
+    if rule.MaxChars > 0 {
+      if length > rule.MaxChars {
+        continue
+      }
+      reasons = append(reasons, fmt.Sprintf("prompt is %d chars, at most %d", length, rule.MaxChars))
+    }
+    if rule.HasCode != nil {
+      if hasCode != *rule.HasCode {
+        continue
+      }
+      if hasCode {
+        reasons = append(reasons, "prompt contains a code block")
+      } else {
+        reasons = append(reasons, "prompt has no code block")
+      }
+    }
+    if len(rule.Keywords) > 0 {
+      keyword := ""
+      for _, k := range rule.Keywords {
+        if strings.Contains(lower, strings.ToLower(k)) {
+          keyword = k
+          break
+        }
+      }
+      if keyword == "" {
+        continue
+      }
+      reasons = append(reasons, fmt.Sprintf("prompt mentions %q", keyword))
+    }
+
+    if len(reasons) == 0 {
+      reasons = append(reasons, "catch-all rule")
+    }
+    return rule.Model, strings.Join(reasons, ", ")
+  }
+  return defaultModel, ""
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+  "regexp"
+  "strings"
+  "unicode"
+)
+
+// ansiEscape matches CSI sequences (colors, cursor movement), OSC sequences
+// (titles, hyperlinks) and the remaining two-byte escapes.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-_]`)
+
+// sanitizeInput strips terminal escape sequences and non-printable control
+// characters, keeping tabs and newlines, so pasted text can't corrupt the
+// terminal when echoed or send junk bytes to the model.
+func sanitizeInput(input string) string {
+  input = strings.ToValidUTF8(input, "")
+  input = ansiEscape.ReplaceAllString(input, "")
+  return strings.Map(func(r rune) rune {
+    if r == '\n' || r == '\t' {
+      return r
+    }
+    if unicode.IsControl(r) {
+      return -1
+    }
+    return r
+  }, input)
+}
+
+// shouldSanitize reports whether input sanitization is enabled. It is on
+// unless the config explicitly sets "sanitize_input": false.
+func shouldSanitize(config Config) bool {
+  return config.SanitizeInput == nil || *config.SanitizeInput
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// Values for Config.ResponseFormat. JSON mode makes the model reply with a
+// JSON object without checking it against a schema.
+const (
+  responseFormatText = "text"
+  responseFormatJSON = "json"
+)
+
+// checkResponseFormat reports whether value is a known response format.
+func checkResponseFormat(value string) error {
+  if value != responseFormatText && value != responseFormatJSON {
+    return fmt.Errorf("unknown response format %q, expected %s or %s", value, responseFormatText, responseFormatJSON)
+  }
+  return nil
+}
+
+// schemaNameInvalid matches characters the API rejects in a schema name.
+var schemaNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func loadResponseSchema(path string) (json.RawMessage, error) {
+  content, err := os.ReadFile(path)
+  if err != nil {
+    return nil, fmt.Errorf("reading response schema: %w", err)
+  }
+
+  var schema map[string]any
+  if err := json.Unmarshal(content, &schema); err != nil {
+    return nil, fmt.Errorf("parsing response schema %s: %w", path, err)
+  }
+  return json.RawMessage(content), nil
+}
+
+// responseFormatForSchema builds a strict structured-output response format
+// named after the schema file.
+func responseFormatForSchema(path string, schema json.RawMessage) *openai.ChatCompletionResponseFormat {
+  name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+  name = schemaNameInvalid.ReplaceAllString(name, "_")
+  if name == "" {
+    name = "response"
+  }
+
+  return &openai.ChatCompletionResponseFormat{
+    Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+    JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+      Name:   name,
+      Schema: schema,
+      Strict: true,
+    },
+  }
+}
+
+// validateResponse checks response against schema and returns a list of
+// violations, empty if it conforms. Only the keywords structured outputs
+// support are checked: type, enum, const, properties, required,
+// additionalProperties, items, anyOf and local $ref.
+func validateResponse(schema json.RawMessage, response string) ([]string, error) {
+  var root any
+  if err := json.Unmarshal(schema, &root); err != nil {
+    return nil, fmt.Errorf("parsing response schema: %w", err)
+  }
+
+  var value any
+  if err := json.Unmarshal([]byte(response), &value); err != nil {
+    return []string{fmt.Sprintf("response is not valid JSON: %v", err)}, nil
+  }
+
+  v := schemaValidator{root: root}
+  v.validate(root, value, "$")
+  return v.violations, nil
+}
+
+type schemaValidator struct {
+  root       any
+  violations []string
+}
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+  v.violations = append(v.violations, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(node, value any, path string) {
+  schema, ok := node.(map[string]any)
+  if !ok {
+    // true/false schemas: false rejects everything.
+    if allowed, isBool := node.(bool); isBool && !allowed {
+      v.fail(path, "not allowed")
+    }
+    return
+  }
+
+  if ref, ok := schema["$ref"].(string); ok {
+    target, err := v.resolve(ref)
+    if err != nil {
+      v.fail(path, "%v", err)
+      return
+    }
+    v.validate(target, value, path)
+    return
+  }
+
+  if anyOf, ok := schema["anyOf"].([]any); ok {
+    matched := false
+    for _, option := range anyOf {
+      sub := schemaValidator{root: v.root}
+      sub.validate(option, value, path)
+      if len(sub.violations) == 0 {
+        matched = true
+        break
+      }
+    }
+    if !matched {
+      v.fail(path, "does not match any allowed schema")
+    }
+  }
+
+  if types := schemaTypes(schema["type"]); len(types) > 0 {
+    actual := jsonType(value)
+    if !typeAllowed(types, actual) {
+      v.fail(path, "expected %s, got %s", strings.Join(types, " or "), actual)
+      return
+    }
+  }
+
+  if enum, ok := schema["enum"].([]any); ok && !containsJSON(enum, value) {
+    v.fail(path, "value %s is not one of the allowed values", compactJSON(value))
+  }
+  if constant, ok := schema["const"]; ok && compactJSON(constant) != compactJSON(value) {
+    v.fail(path, "expected %s", compactJSON(constant))
+  }
+
+  switch value := value.(type) {
+  case map[string]any:
+    properties, _ := schema["properties"].(map[string]any)
+    if required, ok := schema["required"].([]any); ok {
+      for _, name := range required {
+        if key, ok := name.(string); ok {
+          if _, present := value[key]; !present {
+            v.fail(path, "missing required property %q", key)
+          }
+        }
+      }
+    }
+    keys := make([]string, 0, len(value))
+    for key := range value {
+      keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+      child := value[key]
+      childPath := path + "." + key
+      if propSchema, ok := properties[key]; ok {
+        v.validate(propSchema, child, childPath)
+        continue
+      }
+      if additional, ok := schema["additionalProperties"]; ok {
+        if allowed, isBool := additional.(bool); isBool && !allowed {
+          v.fail(path, "unexpected property %q", key)
+          continue
+        }
+        v.validate(additional, child, childPath)
+      }
+    }
+  case []any:
+    if items, ok := schema["items"]; ok {
+      for i, item := range value {
+        v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+      }
+    }
+  }
+}
+
+// resolve looks up a local JSON pointer reference such as "#/$defs/item".
+func (v *schemaValidator) resolve(ref string) (any, error) {
+  if !strings.HasPrefix(ref, "#") {
+    return nil, fmt.Errorf("unsupported $ref %q", ref)
+  }
+
+  node := v.root
+  for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+    if part == "" {
+      continue
+    }
+    part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+    obj, ok := node.(map[string]any)
+    if !ok {
+      return nil, fmt.Errorf("unresolvable $ref %q", ref)
+    }
+    if node, ok = obj[part]; !ok {
+      return nil, fmt.Errorf("unresolvable $ref %q", ref)
+    }
+  }
+  return node, nil
+}
+
+func schemaTypes(raw any) []string {
+  switch t := raw.(type) {
+  case string:
+    return []string{t}
+  case []any:
+    var types []string
+    for _, item := range t {
+      if s, ok := item.(string); ok {
+        types = append(types, s)
+      }
+    }
+    return types
+  }
+  return nil
+}
+
+func jsonType(value any) string {
+  switch value := value.(type) {
+  case nil:
+    return "null"
+  case bool:
+    return "boolean"
+  case string:
+    return "string"
+  case float64:
+    if value == float64(int64(value)) {
+      return "integer"
+    }
+    return "number"
+  case []any:
+    return "array"
+  case map[string]any:
+    return "object"
+  }
+  return "unknown"
+}
+
+func typeAllowed(types []string, actual string) bool {
+  for _, t := range types {
+    if t == actual || (t == "number" && actual == "integer") {
+      return true
+    }
+  }
+  return false
+}
+
+func containsJSON(values []any, value any) bool {
+  encoded := compactJSON(value)
+  for _, candidate := range values {
+    if compactJSON(candidate) == encoded {
+      return true
+    }
+  }
+  return false
+}
+
+func compactJSON(value any) string {
+  out, err := json.Marshal(value)
+  if err != nil {
+    return fmt.Sprint(value)
+  }
+  return string(out)
+}
+
+// schemaViolationError formats violations for display.
+func schemaViolationError(violations []string) error {
+  return fmt.Errorf("response does not match the schema:\n  - %s", strings.Join(violations, "\n  - "))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+  "encoding/json"
+  "slices"
+  "strings"
+  "testing"
+)
+
+func TestValidateResponse(t *testing.T) {
+  tests := []struct {
+    name     string
+    schema   string
+    response string
+    want     []string
+  }{
+    // type
+    {"type matches", `{"type": "string"}`, `"hi"`, nil},
+    {"type mismatch", `{"type": "string"}`, `42`, []string{"$: expected string, got integer"}},
+    {"integer is a number", `{"type": "number"}`, `3`, nil},
+    {"number is not an integer", `{"type": "integer"}`, `3.5`, []string{"$: expected integer, got number"}},
+    {"type list allows null", `{"type": ["string", "null"]}`, `null`, nil},
+    {"type list mismatch", `{"type": ["string", "null"]}`, `true`, []string{"$: expected string or null, got boolean"}},
+
+    // enum and const
+    {"enum match", `{"enum": ["red", "green"]}`, `"green"`, nil},
+    {"enum mismatch", `{"enum": ["red", "green"]}`, `"blue"`, []string{`$: value "blue" is not one of the allowed values`}},
+    {"enum compares objects", `{"enum": [{"a": 1}]}`, `{"a": 1}`, nil},
+    {"const match", `{"const": 7}`, `7`, nil},
+    {"const mismatch", `{"const": 7}`, `8`, []string{"$: expected 7"}},
+
+    // required and additionalProperties
+    {"required present", `{"type": "object", "required": ["a"]}`, `{"a": 1}`, nil},
+    {"required missing", `{"type": "object", "required": ["a", "b"]}`, `{"a": 1}`, []string{`$: missing required property "b"`}},
+    {"additional properties rejected", `{"type": "object", "properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "z": 2, "y": 3}`,
+      []string{`$: unexpected property "y"`, `$: unexpected property "z"`}},
+    {"additional properties by schema", `{"type": "object", "additionalProperties": {"type": "integer"}}`, `{"a": 1, "b": "x"}`,
+      []string{"$.b: expected integer, got string"}},
+    {"additional properties allowed by default", `{"type": "object", "properties": {"a": {}}}`, `{"a": 1, "b": 2}`, nil},
+
+    // items
+    {"items match", `{"type": "array", "items": {"type": "integer"}}`, `[1, 2]`, nil},
+    {"items mismatch", `{"type": "array", "items": {"type": "integer"}}`, `[1, "two", 3, null]`,
+      []string{"$[1]: expected integer, got string", "$[3]: expected integer, got null"}},
+
+    // anyOf
+    {"anyOf first option", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `"x"`, nil},
+    {"anyOf second option", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `5`, nil},
+    {"anyOf no option", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, []string{"$: does not match any allowed schema"}},
+
+    // $ref
+    {"ref resolves", `{"$defs": {"id": {"type": "integer"}}, "$ref": "#/$defs/id"}`, `1`, nil},
+    {"ref mismatch", `{"$defs": {"id": {"type": "integer"}}, "$ref": "#/$defs/id"}`, `"1"`, []string{"$: expected integer, got string"}},
+    {"ref to root", `{"type": "object", "properties": {"child": {"$ref": "#"}}}`, `{"child": {"child": 3}}`,
+      []string{"$.child.child: expected object, got integer"}},
+    {"ref with escaped pointer", `{"$defs": {"a/b": {"type": "string"}}, "$ref": "#/$defs/a~1b"}`, `"x"`, nil},
+    {"ref unresolvable", `{"$ref": "#/$defs/missing"}`, `1`, []string{`$: unresolvable $ref "#/$defs/missing"`}},
+    {"ref through a non-object", `{"$defs": {"a": 1}, "$ref": "#/$defs/a/b"}`, `1`, []string{`$: unresolvable $ref "#/$defs/a/b"`}},
+    {"ref remote", `{"$ref": "other.json#/x"}`, `1`, []string{`$: unsupported $ref "other.json#/x"`}},
+
+    // boolean schemas
+    {"false schema", `{"type": "object", "properties": {"a": false}}`, `{"a": 1}`, []string{"$.a: not allowed"}},
+    {"true schema", `true`, `{"anything": [1]}`, nil},
+
+    // nested paths
+    {"nested path", `{
+      "type": "object",
+      "required": ["users"],
+      "properties": {"users": {"type": "array", "items": {"$ref": "#/$defs/user"}}},
+      "$defs": {"user": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {"name": {"type": "string"}, "tags": {"type": "array", "items": {"enum": ["a", "b"]}}},
+        "additionalProperties": false
+      }}
+    }`, `{"users": [{"name": "ok"}, {"name": 5, "tags": ["a", "c"], "age": 3}, {}]}`, []string{
+      `$.users[1]: unexpected property "age"`,
+      "$.users[1].name: expected string, got integer",
+      `$.users[1].tags[1]: value "c" is not one of the allowed values`,
+      `$.users[2]: missing required property "name"`,
+    }},
+
+    // responses that aren't JSON
+    {"invalid response", `{"type": "object"}`, `{"a": `, []string{"response is not valid JSON: unexpected end of JSON input"}},
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      got, err := validateResponse(json.RawMessage(test.schema), test.response)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if !slices.Equal(got, test.want) {
+        t.Errorf("violations:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(test.want, "\n  "))
+      }
+    })
+  }
+}
+
+func TestValidateResponseInvalidSchema(t *testing.T) {
+  if _, err := validateResponse(json.RawMessage(`{"type": `), `1`); err == nil {
+    t.Error("want an error for a schema that isn't JSON")
+  }
+}
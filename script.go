@@ -0,0 +1,122 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// scriptDelimiter is the line that separates turns in a -script file.
+const scriptDelimiter = "---"
+
+// loadScript reads the user turns of a -script file, or of stdin for "-".
+// Blank turns are skipped.
+func loadScript(path string) ([]string, error) {
+  content, err := readFileOrStdin(path)
+  if err != nil {
+    return nil, fmt.Errorf("reading script: %w", err)
+  }
+
+  var turns []string
+  var current []string
+  flush := func() {
+    if turn := strings.TrimSpace(strings.Join(current, "\n")); turn != "" {
+      turns = append(turns, turn)
+    }
+    current = nil
+  }
+  for _, line := range strings.Split(string(content), "\n") {
+    if strings.TrimSpace(line) == scriptDelimiter {
+      flush()
+      continue
+    }
+    current = append(current, line)
+  }
+  flush()
+
+  if len(turns) == 0 {
+    return nil, fmt.Errorf("script %s has no turns", path)
+  }
+  return turns, nil
+}
+
+// readFileOrStdin reads path, or all of stdin when path is "-".
+func readFileOrStdin(path string) ([]byte, error) {
+  if path == "-" {
+    return io.ReadAll(os.Stdin)
+  }
+  return os.ReadFile(path)
+}
+
+// runScript sends each turn in order as one conversation, printing every
+// response, or with jsonOutput only the final transcript.
+func runScript(backend provider, config Config, turns []string, jsonOutput bool) error {
+  messages := []openai.ChatCompletionMessage{
+    {Role: openai.ChatMessageRoleSystem, Content: systemPrompt(config)},
+  }
+
+  var thread *assistantThread
+  if config.Mode == modeAssistant {
+    var err error
+    thread, err = newAssistantThread(context.Background(), openAIClient(backend), config)
+    if err != nil {
+      return err
+    }
+  }
+
+  for i, turn := range turns {
+    if shouldSanitize(config) {
+      turn = sanitizeInput(turn)
+    }
+    turn, err := runPreRequestHook(config, turn)
+    if err != nil {
+      return fmt.Errorf("turn %d: %w", i+1, err)
+    }
+    if err := moderateInput(context.Background(), backend, config, turn); err != nil {
+      return fmt.Errorf("turn %d: %w", i+1, err)
+    }
+    messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: turn})
+
+    turnConfig := config
+    var result completion
+    if thread != nil {
+      result.content, err = thread.complete(context.Background(), messages)
+    } else {
+      turnConfig.Model = routeTurn(config, turn)
+      result, err = callModel(context.Background(), backend, turnConfig, messages)
+    }
+    if err != nil {
+      return fmt.Errorf("turn %d: %w", i+1, err)
+    }
+    messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: result.content})
+
+    if !jsonOutput {
+      fmt.Println(formatInputPrefix(getCurrentDirectory(), false, false, "") + turn)
+      if err := printFormattedResponse(result.content, answeredConfig(turnConfig, result)); err != nil {
+        return err
+      }
+      if config.ShowUsage && thread == nil {
+        fmt.Println(dimStyle.Render(formatUsage(result.usage, nil)))
+      }
+      fmt.Println()
+    }
+    hookOutput := io.Writer(os.Stdout)
+    if jsonOutput {
+      hookOutput = os.Stderr
+    }
+    runPostResponseHook(config, result.content, hookOutput)
+  }
+
+  if jsonOutput {
+    out, err := marshalCanonicalJSON(sessionMessages(messages))
+    if err != nil {
+      return err
+    }
+    fmt.Print(string(out))
+  }
+  return nil
+}
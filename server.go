@@ -0,0 +1,203 @@
+package main
+
+import (
+  "crypto/subtle"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log/slog"
+  "net"
+  "net/http"
+  "os"
+  "strings"
+  "time"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// maxServeRequestBytes bounds the body of a /v1/chat request.
+const maxServeRequestBytes = 10 << 20
+
+// serveReadHeaderTimeout bounds how long a client may take to send its
+// request headers.
+const serveReadHeaderTimeout = 10 * time.Second
+
+// envServeToken overrides Config.ServeToken, the bearer token -serve
+// requires.
+const envServeToken = "LLM_CLI_SERVE_TOKEN"
+
+// serveChatRequest is the body of POST /v1/chat: either a full message list
+// or a single prompt. The configured system prompt is added when the
+// messages don't start with one.
+type serveChatRequest struct {
+  Messages []sessionMessage `json:"messages"`
+  Prompt   string           `json:"prompt"`
+}
+
+type serveChatResponse struct {
+  Model    string `json:"model"`
+  Response string `json:"response"`
+}
+
+type serveError struct {
+  Error string `json:"error"`
+}
+
+// serve runs the local gateway on addr until it fails. Requests use the
+// configured model, system prompt and parameters with the caller's messages.
+//
+// Anyone who can reach the gateway spends the user's API key, so without a
+// bearer token it only listens on loopback addresses. With a token, every
+// request must carry it in an Authorization header.
+func serve(addr string, backend provider, config Config) error {
+  token := config.ServeToken
+  if env := os.Getenv(envServeToken); env != "" {
+    token = env
+  }
+  if token == "" {
+    loopback, err := isLoopbackAddr(addr)
+    if err != nil {
+      return err
+    }
+    if !loopback {
+      return fmt.Errorf("%s is not a loopback address; set serve_token or $%s to serve it with authentication", addr, envServeToken)
+    }
+  }
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+  })
+  mux.HandleFunc("POST /v1/chat", func(w http.ResponseWriter, r *http.Request) {
+    var request serveChatRequest
+    decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequestBytes))
+    if err := decoder.Decode(&request); err != nil {
+      writeJSON(w, http.StatusBadRequest, serveError{fmt.Sprintf("parsing request: %v", err)})
+      return
+    }
+
+    messages, err := serveMessages(config, request)
+    if err != nil {
+      writeJSON(w, http.StatusBadRequest, serveError{err.Error()})
+      return
+    }
+    if err := moderateInput(r.Context(), backend, config, serveUserText(messages)); err != nil {
+      status := http.StatusBadGateway
+      if isModerationError(err) {
+        status = http.StatusBadRequest
+      }
+      slog.Warn("request not sent", "remote", r.RemoteAddr, "err", err)
+      writeJSON(w, status, serveError{err.Error()})
+      return
+    }
+
+    result, err := callModel(r.Context(), backend, config, messages)
+    if err != nil {
+      slog.Error("request failed", "remote", r.RemoteAddr, "err", err)
+      writeJSON(w, upstreamStatus(err), serveError{err.Error()})
+      return
+    }
+    slog.Info("served chat", "remote", r.RemoteAddr, "messages", len(messages))
+    writeJSON(w, http.StatusOK, serveChatResponse{Model: answeredConfig(config, result).Model, Response: result.content})
+  })
+
+  server := &http.Server{
+    Addr:              addr,
+    Handler:           requireToken(token, mux),
+    ReadHeaderTimeout: serveReadHeaderTimeout,
+  }
+  slog.Info("serving", "addr", addr, "model", config.Model, "auth", token != "")
+  return server.ListenAndServe()
+}
+
+// upstreamStatus is the status to answer with when the API call for a
+// request fails: the API's own status, so clients see rate limits and bad
+// requests as such, or 502 without one. Auth failures also become 502,
+// since they are about the gateway's API key, not the client's token.
+func upstreamStatus(err error) int {
+  switch code := httpStatusCode(err); code {
+  case 0, http.StatusUnauthorized, http.StatusForbidden:
+    return http.StatusBadGateway
+  default:
+    return code
+  }
+}
+
+// requireToken rejects requests without "Authorization: Bearer <token>".
+// An empty token lets every request through.
+func requireToken(token string, next http.Handler) http.Handler {
+  if token == "" {
+    return next
+  }
+  want := []byte("Bearer " + token)
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    got := []byte(r.Header.Get("Authorization"))
+    if subtle.ConstantTimeCompare(got, want) != 1 {
+      slog.Warn("rejected unauthenticated request", "remote", r.RemoteAddr, "path", r.URL.Path)
+      w.Header().Set("WWW-Authenticate", "Bearer")
+      writeJSON(w, http.StatusUnauthorized, serveError{"missing or invalid bearer token"})
+      return
+    }
+    next.ServeHTTP(w, r)
+  })
+}
+
+// isLoopbackAddr reports whether a listen address only accepts local
+// connections. An empty host, as in ":8080", listens on every interface.
+func isLoopbackAddr(addr string) (bool, error) {
+  host, _, err := net.SplitHostPort(addr)
+  if err != nil {
+    return false, fmt.Errorf("invalid address %q: %w", addr, err)
+  }
+  if host == "localhost" {
+    return true, nil
+  }
+  ip := net.ParseIP(host)
+  if ip == nil {
+    if host == "" {
+      return false, nil
+    }
+    return false, errors.New("serve address must be an IP address or localhost unless serve_token is set")
+  }
+  return ip.IsLoopback(), nil
+}
+
+func serveMessages(config Config, request serveChatRequest) ([]openai.ChatCompletionMessage, error) {
+  messages, err := chatMessages(request.Messages)
+  if err != nil {
+    return nil, err
+  }
+  if request.Prompt != "" {
+    messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: request.Prompt})
+  }
+  if len(messages) == 0 {
+    return nil, fmt.Errorf("request needs messages or a prompt")
+  }
+
+  if messages[0].Role != openai.ChatMessageRoleSystem {
+    if prompt := systemPrompt(config); prompt != "" {
+      messages = append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: prompt}}, messages...)
+    }
+  }
+  return messages, nil
+}
+
+// serveUserText joins the user messages of a request, which the caller
+// controls, for moderation.
+func serveUserText(messages []openai.ChatCompletionMessage) string {
+  var texts []string
+  for _, msg := range messages {
+    if msg.Role == openai.ChatMessageRoleUser {
+      texts = append(texts, messageText(msg))
+    }
+  }
+  return strings.Join(texts, "\n\n")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  if err := json.NewEncoder(w).Encode(v); err != nil {
+    slog.Warn("writing response", "err", err)
+  }
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+func TestIsLoopbackAddr(t *testing.T) {
+  tests := []struct {
+    addr string
+    want bool
+  }{
+    {"localhost:8080", true},
+    {"127.0.0.1:8080", true},
+    {"[::1]:8080", true},
+    {":8080", false},
+    {"0.0.0.0:8080", false},
+    {"192.168.1.10:8080", false},
+  }
+  for _, test := range tests {
+    got, err := isLoopbackAddr(test.addr)
+    if err != nil {
+      t.Errorf("isLoopbackAddr(%q): %v", test.addr, err)
+      continue
+    }
+    if got != test.want {
+      t.Errorf("isLoopbackAddr(%q) = %v, want %v", test.addr, got, test.want)
+    }
+  }
+
+  for _, addr := range []string{"example.com:8080", "8080"} {
+    if _, err := isLoopbackAddr(addr); err == nil {
+      t.Errorf("isLoopbackAddr(%q): want an error", addr)
+    }
+  }
+}
+
+func TestRequireToken(t *testing.T) {
+  ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.WriteHeader(http.StatusNoContent)
+  })
+  handler := requireToken("s3cret", ok)
+
+  tests := []struct {
+    header string
+    want   int
+  }{
+    {"", http.StatusUnauthorized},
+    {"Bearer wrong", http.StatusUnauthorized},
+    {"s3cret", http.StatusUnauthorized},
+    {"Bearer s3cret", http.StatusNoContent},
+  }
+  for _, test := range tests {
+    req := httptest.NewRequest(http.MethodGet, "/health", nil)
+    if test.header != "" {
+      req.Header.Set("Authorization", test.header)
+    }
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Code != test.want {
+      t.Errorf("Authorization %q: status %d, want %d", test.header, rec.Code, test.want)
+    }
+  }
+
+  rec := httptest.NewRecorder()
+  requireToken("", ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+  if rec.Code != http.StatusNoContent {
+    t.Errorf("no token: status %d, want %d", rec.Code, http.StatusNoContent)
+  }
+}
+
+func TestUpstreamStatus(t *testing.T) {
+  tests := []struct {
+    err  error
+    want int
+  }{
+    {errors.New("connection refused"), http.StatusBadGateway},
+    {&openai.APIError{HTTPStatusCode: http.StatusUnauthorized}, http.StatusBadGateway},
+    {&openai.APIError{HTTPStatusCode: http.StatusForbidden}, http.StatusBadGateway},
+    {&anthropicError{HTTPStatusCode: http.StatusUnauthorized}, http.StatusBadGateway},
+    {&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, http.StatusTooManyRequests},
+    {&openai.RequestError{HTTPStatusCode: http.StatusBadRequest}, http.StatusBadRequest},
+    {&openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}, http.StatusServiceUnavailable},
+  }
+  for _, test := range tests {
+    if got := upstreamStatus(test.err); got != test.want {
+      t.Errorf("upstreamStatus(%v) = %d, want %d", test.err, got, test.want)
+    }
+  }
+}
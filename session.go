@@ -0,0 +1,202 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/fs"
+  "log/slog"
+  "os"
+  "os/signal"
+  "path/filepath"
+  "slices"
+  "strings"
+  "sync"
+  "syscall"
+  "time"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// sessionMessage is one entry of a saved session file. Sessions are a JSON
+// array of these, written with marshalCanonicalJSON so they diff cleanly.
+type sessionMessage struct {
+  Role    string `json:"role"`
+  Content string `json:"content"`
+}
+
+func sessionMessages(messages []openai.ChatCompletionMessage) []sessionMessage {
+  session := make([]sessionMessage, 0, len(messages))
+  for _, msg := range messages {
+    session = append(session, sessionMessage{Role: msg.Role, Content: messageText(msg)})
+  }
+  return session
+}
+
+// chatMessages converts session entries back to chat messages, rejecting
+// roles other than system, user and assistant.
+func chatMessages(session []sessionMessage) ([]openai.ChatCompletionMessage, error) {
+  messages := make([]openai.ChatCompletionMessage, 0, len(session))
+  for i, msg := range session {
+    switch msg.Role {
+    case openai.ChatMessageRoleSystem, openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+    default:
+      return nil, fmt.Errorf("message %d has unsupported role %q", i, msg.Role)
+    }
+    messages = append(messages, openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content})
+  }
+  return messages, nil
+}
+
+func writeSession(path string, messages []openai.ChatCompletionMessage) error {
+  out, err := marshalCanonicalJSON(sessionMessages(messages))
+  if err != nil {
+    return err
+  }
+  return os.WriteFile(path, out, 0o644)
+}
+
+// maxSessionSuffix bounds how many numbered names writeNewSession tries.
+const maxSessionSuffix = 1000
+
+// writeNewSession writes messages to a file that doesn't exist yet: path, or
+// if that is taken, the first free one of name-2.json, name-3.json and so on.
+// It returns the path written.
+func writeNewSession(path string, messages []openai.ChatCompletionMessage) (string, error) {
+  out, err := marshalCanonicalJSON(sessionMessages(messages))
+  if err != nil {
+    return "", err
+  }
+  ext := filepath.Ext(path)
+  base := strings.TrimSuffix(path, ext)
+  for n := 1; n <= maxSessionSuffix; n++ {
+    candidate := path
+    if n > 1 {
+      candidate = fmt.Sprintf("%s-%d%s", base, n, ext)
+    }
+    f, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+    if errors.Is(err, fs.ErrExist) {
+      continue
+    }
+    if err != nil {
+      return "", err
+    }
+    if _, err := f.Write(out); err != nil {
+      f.Close()
+      os.Remove(candidate)
+      return "", err
+    }
+    if err := f.Close(); err != nil {
+      os.Remove(candidate)
+      return "", err
+    }
+    return candidate, nil
+  }
+  return "", fmt.Errorf("%s and %d numbered variants already exist", path, maxSessionSuffix-1)
+}
+
+// defaultSessionName is the file :save writes when no name is given.
+func defaultSessionName(now time.Time) string {
+  return "session-" + now.Format("20060102-150405") + ".json"
+}
+
+// sessionVarsPath is where saveSession keeps a session's variables: beside
+// the session file, so the session itself stays a plain message list.
+func sessionVarsPath(path string) string {
+  return strings.TrimSuffix(path, filepath.Ext(path)) + ".vars.json"
+}
+
+// saveSession writes messages to path and, when there are any, vars to
+// sessionVarsPath(path).
+func saveSession(path string, messages []openai.ChatCompletionMessage, vars map[string]string) error {
+  if err := writeSession(path, messages); err != nil {
+    return err
+  }
+  if len(vars) == 0 {
+    return nil
+  }
+  out, err := marshalCanonicalJSON(vars)
+  if err != nil {
+    return err
+  }
+  return os.WriteFile(sessionVarsPath(path), out, 0o644)
+}
+
+// loadSession reads a session written by saveSession, with its variables if
+// a vars file exists beside it.
+func loadSession(path string) ([]openai.ChatCompletionMessage, map[string]string, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, nil, err
+  }
+  var session []sessionMessage
+  if err := json.Unmarshal(data, &session); err != nil {
+    return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+  }
+  messages, err := chatMessages(session)
+  if err != nil {
+    return nil, nil, fmt.Errorf("%s: %w", path, err)
+  }
+  if len(messages) == 0 {
+    return nil, nil, fmt.Errorf("%s has no messages", path)
+  }
+
+  vars := map[string]string{}
+  varsPath := sessionVarsPath(path)
+  data, err = os.ReadFile(varsPath)
+  if err != nil && !errors.Is(err, fs.ErrNotExist) {
+    return nil, nil, err
+  }
+  if err == nil {
+    if err := json.Unmarshal(data, &vars); err != nil {
+      return nil, nil, fmt.Errorf("parsing %s: %w", varsPath, err)
+    }
+  }
+  return messages, vars, nil
+}
+
+// autoSaver keeps the interactive conversation written to a session file.
+// The latest snapshot is guarded by a mutex so a signal handler can save it
+// while the REPL goroutine is busy.
+type autoSaver struct {
+  path     string
+  mu       sync.Mutex
+  messages []openai.ChatCompletionMessage
+}
+
+// update records messages and writes them if they changed since the last
+// save.
+func (a *autoSaver) update(messages []openai.ChatCompletionMessage) error {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  if slices.EqualFunc(a.messages, messages, sameMessage) {
+    return nil
+  }
+  a.messages = slices.Clone(messages)
+  return writeSession(a.path, a.messages)
+}
+
+// save writes the latest snapshot.
+func (a *autoSaver) save() error {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  return writeSession(a.path, a.messages)
+}
+
+// saveOnSignal installs a handler that saves the conversation and exits when
+// the process is terminated or its terminal is closed. Ctrl-C is handled by
+// the REPL, which saves before exiting at the prompt.
+func (a *autoSaver) saveOnSignal() {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+  go func() {
+    sig := <-signals
+    if err := a.save(); err != nil {
+      slog.Error("saving conversation", "path", a.path, "err", err)
+      os.Exit(1)
+    }
+    fmt.Println()
+    slog.Info("saved conversation before exiting", "path", a.path, "signal", sig.String())
+    os.Exit(1)
+  }()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+  "strings"
+  "unicode"
+)
+
+// defaultDuplicateThreshold is used when Config.DuplicateThreshold is zero.
+const defaultDuplicateThreshold = 0.9
+
+// responseSimilarity scores two texts from 0 (nothing shared) to 1 (same
+// words with the same frequencies), using the Dice coefficient over
+// lower-cased word counts. Order is ignored, which is fine for telling
+// whether a regenerated answer says anything new.
+func responseSimilarity(a, b string) float64 {
+  countsA, totalA := wordCounts(a)
+  countsB, totalB := wordCounts(b)
+  if totalA+totalB == 0 {
+    return 1
+  }
+
+  shared := 0
+  for word, n := range countsA {
+    shared += min(n, countsB[word])
+  }
+  return 2 * float64(shared) / float64(totalA+totalB)
+}
+
+func wordCounts(text string) (map[string]int, int) {
+  words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+  })
+  counts := make(map[string]int, len(words))
+  for _, word := range words {
+    counts[word]++
+  }
+  return counts, len(words)
+}
+
+// isNearDuplicate applies Config.DuplicateThreshold: zero means the default
+// and a negative value turns detection off.
+func isNearDuplicate(config Config, previous, current string) bool {
+  threshold := config.DuplicateThreshold
+  if threshold < 0 {
+    return false
+  }
+  if threshold == 0 {
+    threshold = defaultDuplicateThreshold
+  }
+  return responseSimilarity(previous, current) >= threshold
+}
@@ -0,0 +1,22 @@
+package main
+
+// outputKind is what a block of interactive output was: the reply to a
+// command, a model response or an error.
+type outputKind int
+
+const (
+  outputCommand outputKind = iota
+  outputResponse
+  outputError
+)
+
+// separatorAfter returns what is printed after a block of interactive
+// output, before the next prompt: one blank line, or none with
+// Config.CompactOutput. The kind is deliberately not a factor, so errors and
+// responses keep the same rhythm as command output.
+func separatorAfter(kind outputKind, compact bool) string {
+  if compact {
+    return ""
+  }
+  return "\n"
+}
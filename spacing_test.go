@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestSeparatorAfter(t *testing.T) {
+  tests := []struct {
+    name    string
+    kind    outputKind
+    compact bool
+    want    string
+  }{
+    {"command", outputCommand, false, "\n"},
+    {"response", outputResponse, false, "\n"},
+    {"error", outputError, false, "\n"},
+    {"compact command", outputCommand, true, ""},
+    {"compact response", outputResponse, true, ""},
+    {"compact error", outputError, true, ""},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if got := separatorAfter(tt.kind, tt.compact); got != tt.want {
+        t.Errorf("separatorAfter(%v, %v) = %q, want %q", tt.kind, tt.compact, got, tt.want)
+      }
+    })
+  }
+}
+
+// Errors and responses used to be followed by different amounts of space;
+// within a mode, every kind must now end the same way.
+func TestSeparatorAfterIsUniform(t *testing.T) {
+  for _, compact := range []bool{false, true} {
+    want := separatorAfter(outputCommand, compact)
+    for _, kind := range []outputKind{outputResponse, outputError} {
+      if got := separatorAfter(kind, compact); got != want {
+        t.Errorf("compact=%v: kind %v gets %q, command output gets %q", compact, kind, got, want)
+      }
+    }
+  }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "time"
+
+  "github.com/charmbracelet/lipgloss"
+  "golang.org/x/term"
+)
+
+var (
+  spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+  spinnerStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("183"))
+)
+
+// spinnerInterval is the time between frames.
+const spinnerInterval = 80 * time.Millisecond
+
+// spinner animates a one-line "waiting" indicator on its own goroutine while
+// a response is requested. Like liveCost, a nil *spinner is valid and does
+// nothing.
+type spinner struct {
+  quit chan struct{}
+  done chan struct{}
+}
+
+// startSpinner starts a spinner labelled with the model being waited on. It
+// returns nil when stdout is not a terminal. The cursor must be at the start
+// of a line.
+func startSpinner(model string) *spinner {
+  if !term.IsTerminal(int(os.Stdout.Fd())) {
+    return nil
+  }
+  s := &spinner{quit: make(chan struct{}), done: make(chan struct{})}
+  go func() {
+    defer close(s.done)
+    ticker := time.NewTicker(spinnerInterval)
+    defer ticker.Stop()
+    for frame := 0; ; frame++ {
+      fmt.Printf("\r%s %s", spinnerStyle.Render(spinnerFrames[frame%len(spinnerFrames)]), dimStyle.Render("Waiting for "+model+"..."))
+      select {
+      case <-s.quit:
+        fmt.Print("\r\x1b[K")
+        return
+      case <-ticker.C:
+      }
+    }
+  }()
+  return s
+}
+
+// stop ends the animation and clears its line, returning once the line is
+// clear so output that follows isn't overwritten.
+func (s *spinner) stop() {
+  if s == nil {
+    return
+  }
+  close(s.quit)
+  <-s.done
+}
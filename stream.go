@@ -0,0 +1,130 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// streamOpenAI prints the response header and then each token as it
+// arrives, returning the full response once the stream ends. Tokens are
+// printed as plain text because markdown can't be rendered until complete.
+//
+// If config.StreamStopMarker appears in the output, the request is cancelled
+// and only the text before the marker is kept. Output that could be the start
+// of the marker is held back until it is known not to be.
+//
+// With config.LiveCost on a terminal, a running token and cost estimate is
+// shown while streaming and reconciled with the reported usage at the end.
+// Usage is requested for LiveCost and ShowUsage.
+//
+// Config.TimeoutSeconds covers the whole stream, not just its first token.
+func streamOpenAI(ctx context.Context, client *openai.Client, config Config, messages []openai.ChatCompletionMessage) (completion, error) {
+  parent := ctx
+  ctx, cancel := withRequestTimeout(ctx, config)
+  defer cancel()
+
+  live := newLiveCost(config, messages)
+  defer live.stop()
+
+  var stream *openai.ChatCompletionStream
+  model, err := withFallback(config, func(model string) error {
+    request := newChatRequest(config, model, messages)
+    request.Stream = true
+    if live != nil || config.ShowUsage {
+      request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+    }
+    if live != nil {
+      live.model = model
+    }
+
+    return withRetry(ctx, config, func() error {
+      var err error
+      stream, err = client.CreateChatCompletionStream(ctx, request)
+      return err
+    })
+  })
+  if err != nil {
+    return completion{}, describeTimeout(parent, config, err)
+  }
+  defer stream.Close()
+
+  if !plainOutput(config) {
+    printResponseHeader(config.AIName, model)
+    fmt.Println()
+  }
+  live.start()
+
+  marker := config.StreamStopMarker
+  holdback := max(len(marker)-1, 0)
+
+  var buf strings.Builder
+  var response string
+  var usage *openai.Usage
+  var fingerprint string
+  printed := 0
+  for {
+    chunk, err := stream.Recv()
+    if errors.Is(err, io.EOF) {
+      break
+    }
+    if err != nil {
+      fmt.Println(response[printed:])
+      return completion{content: response}, describeTimeout(parent, config, err)
+    }
+    if chunk.Usage != nil {
+      usage = chunk.Usage
+    }
+    if chunk.SystemFingerprint != "" {
+      fingerprint = chunk.SystemFingerprint
+    }
+    if len(chunk.Choices) == 0 {
+      continue
+    }
+
+    // Only the new text, plus enough before it to catch a marker split
+    // across chunks, needs searching.
+    searchFrom := max(buf.Len()-len(marker)+1, 0)
+    buf.WriteString(chunk.Choices[0].Delta.Content)
+    response = buf.String()
+
+    if marker != "" {
+      if i := strings.Index(response[searchFrom:], marker); i >= 0 {
+        response = response[:searchFrom+i]
+        cancel()
+        break
+      }
+    }
+    if safe := len(response) - holdback; safe > printed {
+      fmt.Print(response[printed:safe])
+      printed = safe
+    }
+    live.update(response)
+  }
+
+  if printed < len(response) {
+    fmt.Print(response[printed:])
+  }
+  fmt.Println()
+  live.report(usage, response)
+
+  if config.responseSchema != nil {
+    violations, err := validateResponse(config.responseSchema, response)
+    if err != nil {
+      return completion{}, err
+    }
+    if len(violations) > 0 {
+      return completion{}, schemaViolationError(violations)
+    }
+  }
+  logFingerprint(config, fingerprint)
+  result := completion{content: response, fingerprint: fingerprint, model: model}
+  if usage != nil {
+    result.usage = *usage
+  }
+  return result, nil
+}
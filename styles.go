@@ -0,0 +1,103 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "slices"
+  "strings"
+
+  "github.com/charmbracelet/glamour"
+  "github.com/charmbracelet/glamour/ansi"
+  "github.com/charmbracelet/lipgloss"
+  "github.com/muesli/termenv"
+  "golang.org/x/term"
+)
+
+// stylePreview is the markdown rendered under each name by -styles.
+const stylePreview = "## Heading\n\nSome **bold** text, a [link](https://example.com) and `inline code`.\n\n```go\nfmt.Println(\"hello\")\n```\n"
+
+// builtinStyle returns the glamour style called name: one of
+// glamour.DefaultStyles, or "auto" for dark or light to suit the terminal's
+// background.
+func builtinStyle(name string) (ansi.StyleConfig, bool) {
+  if name == glamour.AutoStyle {
+    if termenv.HasDarkBackground() {
+      return glamour.DarkStyleConfig, true
+    }
+    return glamour.LightStyleConfig, true
+  }
+  if styles, ok := glamour.DefaultStyles[name]; ok {
+    return *styles, true
+  }
+  return ansi.StyleConfig{}, false
+}
+
+func isBuiltinStyle(name string) bool {
+  _, ok := glamour.DefaultStyles[name]
+  return ok || name == glamour.AutoStyle
+}
+
+// styleExists reports whether name is a built-in style or a file in one of
+// the styles folders.
+func styleExists(name string) bool {
+  if isBuiltinStyle(name) {
+    return true
+  }
+  _, err := findConfigFile(filepath.Join("styles", name+".json"))
+  return err == nil
+}
+
+// availableStyles lists the built-in styles and the style names found in
+// the styles folder of each config search directory.
+func availableStyles() []string {
+  names := []string{glamour.AutoStyle}
+  for name := range glamour.DefaultStyles {
+    names = append(names, name)
+  }
+  for _, dir := range configSearchDirs() {
+    entries, err := os.ReadDir(filepath.Join(dir, "styles"))
+    if err != nil {
+      continue
+    }
+    for _, entry := range entries {
+      if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+        names = append(names, name)
+      }
+    }
+  }
+  slices.Sort(names)
+  return slices.Compact(names)
+}
+
+// printStyles lists the available styles for -styles. On a terminal each
+// name is followed by a short sample rendered in that style; otherwise only
+// the names are printed, one per line.
+func printStyles() error {
+  names := availableStyles()
+  if !term.IsTerminal(int(os.Stdout.Fd())) {
+    for _, name := range names {
+      fmt.Println(name)
+    }
+    return nil
+  }
+
+  nameStyle := lipgloss.NewStyle().Bold(true)
+  for _, name := range names {
+    label := nameStyle.Render(name)
+    if isBuiltinStyle(name) {
+      label += " " + dimStyle.Render("(built in)")
+    }
+    fmt.Println(label)
+    renderer, err := newTermRenderer(Config{Style: name})
+    if err != nil {
+      return err
+    }
+    preview, err := renderer.Render(stylePreview)
+    if err != nil {
+      return fmt.Errorf("rendering style %s: %w", name, err)
+    }
+    fmt.Print(preview)
+  }
+  return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "text/tabwriter"
+  "time"
+)
+
+// phaseTimings records how long each named phase of a run took.
+type phaseTimings struct {
+  names     []string
+  durations map[string]time.Duration
+}
+
+func newPhaseTimings() *phaseTimings {
+  return &phaseTimings{durations: map[string]time.Duration{}}
+}
+
+// record adds the time elapsed since start to the named phase.
+func (t *phaseTimings) record(name string, start time.Time) {
+  if _, ok := t.durations[name]; !ok {
+    t.names = append(t.names, name)
+  }
+  t.durations[name] += time.Since(start)
+}
+
+func (t *phaseTimings) print(w io.Writer) {
+  var total time.Duration
+  for _, name := range t.names {
+    total += t.durations[name]
+  }
+
+  tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+  fmt.Fprintln(tw, "Phase\tTime\tShare\t")
+  for _, name := range t.names {
+    d := t.durations[name]
+    share := 0.0
+    if total > 0 {
+      share = float64(d) / float64(total) * 100
+    }
+    fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t\n", name, d.Round(time.Microsecond), share)
+  }
+  fmt.Fprintf(tw, "total\t%s\t\t\n", total.Round(time.Microsecond))
+  tw.Flush()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// Rough tokenizer-free estimates: about four characters per token, plus a
+// few tokens of framing per message.
+const (
+  charsPerToken =    4
+  tokensPerMessage = 4
+)
+
+// contextWindows maps model name prefixes to their context window in tokens.
+// Longer prefixes are listed first so "gpt-4o" wins over "gpt-4".
+var contextWindows = []struct {
+  prefix string
+  tokens int
+}{
+  {"gpt-4o", 128000},
+  {"gpt-4-turbo", 128000},
+  {"gpt-4-32k", 32768},
+  {"gpt-4", 8192},
+  {"gpt-3.5-turbo", 16385},
+  {"o1", 128000},
+}
+
+// outputLimits maps model name prefixes to the most tokens a single
+// completion may contain, ordered like contextWindows.
+var outputLimits = []struct {
+  prefix string
+  tokens int
+}{
+  {"gpt-4o", 16384},
+  {"gpt-4-turbo", 4096},
+  {"gpt-4-32k", 32768},
+  {"gpt-4", 8192},
+  {"gpt-3.5-turbo", 4096},
+  {"o1-mini", 65536},
+  {"o1", 32768},
+}
+
+func estimateTokens(text string) int {
+  return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+func estimateMessageTokens(messages []openai.ChatCompletionMessage) int {
+  total := 0
+  for _, msg := range messages {
+    total += tokensPerMessage + estimateTokens(messageText(msg))
+  }
+  return total
+}
+
+// Config.WarnOnSpike flags an input at least spikeFactor times the average
+// of the last spikeWindow user messages, once it is over spikeMinTokens.
+const (
+  spikeFactor =    4
+  spikeWindow =    3
+  spikeMinTokens = 1000
+)
+
+// tokenSpike reports whether input is unusually large compared with the
+// recent user messages in history, returning its estimate and the average it
+// was compared with. Fewer than two earlier messages is too little to judge.
+func tokenSpike(history []openai.ChatCompletionMessage, input string) (tokens, baseline int, spike bool) {
+  tokens = estimateTokens(input)
+  var recent []int
+  for i := len(history) - 1; i >= 0 && len(recent) < spikeWindow; i-- {
+    if history[i].Role == openai.ChatMessageRoleUser {
+      recent = append(recent, estimateTokens(messageText(history[i])))
+    }
+  }
+  if len(recent) < 2 {
+    return tokens, 0, false
+  }
+
+  total := 0
+  for _, n := range recent {
+    total += n
+  }
+  baseline = total / len(recent)
+  return tokens, baseline, tokens >= spikeMinTokens && tokens >= spikeFactor*max(baseline, 1)
+}
+
+// contextWindow returns the context window for model, or 0 if unknown.
+func contextWindow(model string) int {
+  for _, w := range contextWindows {
+    if strings.HasPrefix(model, w.prefix) {
+      return w.tokens
+    }
+  }
+  return 0
+}
+
+// outputLimit returns the completion token limit for model, or 0 if unknown.
+func outputLimit(model string) int {
+  for _, l := range outputLimits {
+    if strings.HasPrefix(model, l.prefix) {
+      return l.tokens
+    }
+  }
+  return 0
+}
+
+func formatTokenCount(tokens int) string {
+  if tokens < 1000 {
+    return fmt.Sprintf("%d", tokens)
+  }
+  if tokens%1000 == 0 || tokens >= 100000 {
+    return fmt.Sprintf("%dk", tokens/1000)
+  }
+  return fmt.Sprintf("%.1fk", float64(tokens)/1000)
+}
+
+// formatContextMeter renders approximate context usage like "3.2k/128k".
+func formatContextMeter(messages []openai.ChatCompletionMessage, model string) string {
+  used := formatTokenCount(estimateMessageTokens(messages))
+  window := contextWindow(model)
+  if window == 0 {
+    return used
+  }
+  return fmt.Sprintf("%s/%s", used, formatTokenCount(window))
+}
+
+func addUsage(a, b openai.Usage) openai.Usage {
+  return openai.Usage{
+    PromptTokens:     a.PromptTokens + b.PromptTokens,
+    CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+    TotalTokens:      a.TotalTokens + b.TotalTokens,
+  }
+}
+
+// formatUsage shows the reported tokens for one response, followed by the
+// session total when there is one.
+func formatUsage(usage openai.Usage, session *openai.Usage) string {
+  out := fmt.Sprintf("tokens: %d in, %d out, %d total", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+  if session != nil {
+    out += fmt.Sprintf(" (session: %d in, %d out, %d total)", session.PromptTokens, session.CompletionTokens, session.TotalTokens)
+  }
+  return out
+}
+
+// trimContext drops the oldest turns until messages fit in budget tokens,
+// returning the kept messages and how many were dropped. The leading system
+// message and the latest message are always kept, and the history after the
+// system message is made to start with a user message.
+func trimContext(messages []openai.ChatCompletionMessage, budget int) ([]openai.ChatCompletionMessage, int) {
+  keep := 0
+  if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+    keep = 1
+  }
+
+  trimmed := messages
+  total := estimateMessageTokens(messages)
+  dropped := 0
+  for total > budget && len(trimmed) > keep+1 {
+    total -= tokensPerMessage + estimateTokens(messageText(trimmed[keep]))
+    trimmed = append(trimmed[:keep:keep], trimmed[keep+1:]...)
+    dropped++
+  }
+  for len(trimmed) > keep+1 && trimmed[keep].Role == openai.ChatMessageRoleAssistant {
+    trimmed = append(trimmed[:keep:keep], trimmed[keep+1:]...)
+    dropped++
+  }
+  return trimmed, dropped
+}
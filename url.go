@@ -0,0 +1,154 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "mime"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+
+  "golang.org/x/net/html"
+)
+
+// Limits for :url. A page's HTML is usually much bigger than its text, so the
+// download may be larger than the text that is kept.
+const (
+  urlFetchTimeout = 15 * time.Second
+  maxURLBodyBytes = 4 << 20
+)
+
+// skippedHTMLElements hold no readable text. Of <head>, only the title is
+// text, and it is kept.
+var skippedHTMLElements = map[string]bool{
+  "script": true, "style": true, "noscript": true, "template": true, "svg": true,
+}
+
+// blockHTMLElements start a new line in the extracted text.
+var blockHTMLElements = map[string]bool{
+  "p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true, "tr": true, "table": true,
+  "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true,
+  "section": true, "article": true, "header": true, "footer": true, "nav": true, "main": true, "hr": true, "title": true,
+}
+
+// fetchURL downloads a web page for :url and returns its readable text. HTML
+// is reduced to plain text; other text types are returned as they are. The
+// text is held to the same limit as a file added with :file.
+func fetchURL(ctx context.Context, rawURL string) (string, error) {
+  parsed, err := url.Parse(rawURL)
+  if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+    return "", fmt.Errorf("%q is not an http or https URL", rawURL)
+  }
+
+  ctx, cancel := context.WithTimeout(ctx, urlFetchTimeout)
+  defer cancel()
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+  if err != nil {
+    return "", err
+  }
+  req.Header.Set("User-Agent", "llm-cli")
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    return "", fmt.Errorf("fetching %s: %w", rawURL, err)
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return "", fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+  }
+
+  body, err := io.ReadAll(io.LimitReader(resp.Body, maxURLBodyBytes+1))
+  if err != nil {
+    return "", fmt.Errorf("fetching %s: %w", rawURL, err)
+  }
+  if len(body) > maxURLBodyBytes {
+    return "", fmt.Errorf("%s is over %s", rawURL, formatByteSize(maxURLBodyBytes))
+  }
+
+  mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+  var text string
+  switch {
+  case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+    text = htmlToText(string(body))
+  case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "":
+    if looksBinary(body) {
+      return "", fmt.Errorf("%s is not text", rawURL)
+    }
+    text = string(body)
+  default:
+    return "", fmt.Errorf("%s is %s, not text", rawURL, mediaType)
+  }
+
+  text = strings.TrimSpace(text)
+  if text == "" {
+    return "", fmt.Errorf("%s has no readable text", rawURL)
+  }
+  if len(text) > maxContextFileBytes {
+    return "", fmt.Errorf("%s has %s of text, over the %s limit", rawURL, formatByteSize(len(text)), formatByteSize(maxContextFileBytes))
+  }
+  return text, nil
+}
+
+// htmlToText extracts the visible text of an HTML document: scripts, styles
+// and the like are dropped, block elements become line breaks and runs of
+// whitespace outside <pre> are collapsed.
+func htmlToText(document string) string {
+  tokenizer := html.NewTokenizer(strings.NewReader(document))
+  var out strings.Builder
+  skipping := 0
+  inPre := 0
+  newline := func() {
+    if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+      out.WriteByte('\n')
+    }
+  }
+
+  for {
+    tokenType := tokenizer.Next()
+    switch tokenType {
+    case html.ErrorToken:
+      return out.String()
+    case html.StartTagToken, html.SelfClosingTagToken:
+      name, _ := tokenizer.TagName()
+      tag := string(name)
+      // A self-closing <svg/> has no end tag to stop skipping at.
+      if skippedHTMLElements[tag] && tokenType == html.StartTagToken {
+        skipping++
+      }
+      if tag == "pre" {
+        inPre++
+      }
+      if blockHTMLElements[tag] {
+        newline()
+      }
+    case html.EndTagToken:
+      name, _ := tokenizer.TagName()
+      tag := string(name)
+      if skippedHTMLElements[tag] && skipping > 0 {
+        skipping--
+      }
+      if tag == "pre" && inPre > 0 {
+        inPre--
+      }
+      if blockHTMLElements[tag] {
+        newline()
+      }
+    case html.TextToken:
+      if skipping > 0 {
+        continue
+      }
+      text := string(tokenizer.Text())
+      if inPre == 0 {
+        text = strings.Join(strings.Fields(text), " ")
+        if text == "" {
+          continue
+        }
+        if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+          out.WriteByte(' ')
+        }
+      }
+      out.WriteString(text)
+    }
+  }
+}
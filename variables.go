@@ -0,0 +1,87 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+var (
+  variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+  variableRef  = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// extractInstruction is appended to a :set instruction so the reply is just
+// the value.
+const extractInstruction = "Reply with only the requested value, with no explanation or formatting."
+
+// expandVariables replaces ${name} references with their values. Unknown
+// names are left as written and returned so the caller can warn.
+func expandVariables(text string, vars map[string]string) (string, []string) {
+  var missing []string
+  expanded := variableRef.ReplaceAllStringFunc(text, func(ref string) string {
+    name := ref[2 : len(ref)-1]
+    value, ok := vars[name]
+    if !ok {
+      missing = append(missing, name)
+      return ref
+    }
+    return value
+  })
+  return expanded, missing
+}
+
+// extractVariable asks the model to pull a value out of the conversation.
+// The request is separate from the conversation, which is not modified.
+func extractVariable(ctx context.Context, backend provider, config Config, messages []openai.ChatCompletionMessage, instruction string) (string, error) {
+  request := append(append([]openai.ChatCompletionMessage{}, messages...), openai.ChatCompletionMessage{
+    Role:    openai.ChatMessageRoleUser,
+    Content: instruction + "\n\n" + extractInstruction,
+  })
+
+  extractConfig := config
+  extractConfig.responseSchema = nil
+  result, err := requestCompletion(ctx, backend, extractConfig, request)
+  if err != nil {
+    return "", err
+  }
+  return strings.TrimSpace(result.content), nil
+}
+
+// parseSetCommand splits ":set name = instruction" into its parts.
+func parseSetCommand(args string) (name, instruction string, err error) {
+  name, instruction, ok := strings.Cut(args, "=")
+  name = strings.TrimSpace(name)
+  instruction = strings.TrimSpace(instruction)
+  if !ok || instruction == "" {
+    return "", "", fmt.Errorf("usage: %s <name> = <what to extract>", cmdSet)
+  }
+  if !variableName.MatchString(name) {
+    return "", "", fmt.Errorf("invalid variable name %q: use letters, digits and underscores", name)
+  }
+  return name, instruction, nil
+}
+
+func formatVariables(vars map[string]string) string {
+  if len(vars) == 0 {
+    return "No variables defined."
+  }
+  names := make([]string, 0, len(vars))
+  for name := range vars {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  var out strings.Builder
+  for i, name := range names {
+    if i > 0 {
+      out.WriteByte('\n')
+    }
+    fmt.Fprintf(&out, "  %-16s %s", name, dimStyle.Render(truncateValue(vars[name])))
+  }
+  return out.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+  "encoding/base64"
+  "fmt"
+  "net/http"
+  "os"
+  "slices"
+  "strings"
+
+  "github.com/sashabaranov/go-openai"
+)
+
+// maxImageBytes is the largest image -image and :image will send; the API
+// rejects bigger ones.
+const maxImageBytes = 20 << 20
+
+// imageTypes are the image formats vision models accept, by the MIME type
+// http.DetectContentType reports.
+var imageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// visionModelPrefixes lists OpenAI models that accept images. Models behind
+// a custom base_url aren't checked, since any of them might.
+var visionModelPrefixes = []string{"gpt-4o", "chatgpt-4o", "gpt-4-turbo", "gpt-4-vision", "gpt-4.1", "gpt-4.5", "gpt-5", "o4-mini"}
+
+// checkVision reports why images can't be sent to config.Model, if they
+// can't.
+func checkVision(config Config) error {
+  if config.Provider != "" && config.Provider != providerOpenAI {
+    return fmt.Errorf("images are only supported with the %s provider", providerOpenAI)
+  }
+  if config.Mode == modeAssistant {
+    return fmt.Errorf("images are not supported in %s mode", modeAssistant)
+  }
+  if config.BaseURL != "" || config.AzureEndpoint != "" {
+    return nil
+  }
+  for _, prefix := range visionModelPrefixes {
+    if strings.HasPrefix(config.Model, prefix) {
+      return nil
+    }
+  }
+  return fmt.Errorf("%s does not accept images; use a vision model such as gpt-4o", config.Model)
+}
+
+// loadImage reads an image file into a message part, inlined as a data URL.
+func loadImage(path string) (openai.ChatMessagePart, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return openai.ChatMessagePart{}, fmt.Errorf("reading image: %w", err)
+  }
+  if len(data) > maxImageBytes {
+    return openai.ChatMessagePart{}, fmt.Errorf("%s is %s, over the %s limit for images", path, formatByteSize(len(data)), formatByteSize(maxImageBytes))
+  }
+  mimeType := http.DetectContentType(data)
+  if !slices.Contains(imageTypes, mimeType) {
+    return openai.ChatMessagePart{}, fmt.Errorf("%s is not a PNG, JPEG, GIF or WebP image", path)
+  }
+  return openai.ChatMessagePart{
+    Type: openai.ChatMessagePartTypeImageURL,
+    ImageURL: &openai.ChatMessageImageURL{
+      URL: "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data),
+    },
+  }, nil
+}
+
+// describeImage summarises an image part loaded by loadImage as its type and
+// size, e.g. "image/png, 12.3 KB".
+func describeImage(part openai.ChatMessagePart) string {
+  if part.ImageURL == nil {
+    return "(no image)"
+  }
+  header, data, ok := strings.Cut(strings.TrimPrefix(part.ImageURL.URL, "data:"), ";base64,")
+  if !ok {
+    return part.ImageURL.URL
+  }
+  return fmt.Sprintf("%s, %s", header, formatByteSize(base64.StdEncoding.DecodedLen(len(data))))
+}
+
+// imageMessage builds a user message with text followed by images.
+func imageMessage(text string, images []openai.ChatMessagePart) openai.ChatCompletionMessage {
+  parts := append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: text}}, images...)
+  return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: parts}
+}
+
+// messageText returns the text of a message, joining the text parts of one
+// with images.
+func messageText(msg openai.ChatCompletionMessage) string {
+  if len(msg.MultiContent) == 0 {
+    return msg.Content
+  }
+  var texts []string
+  for _, part := range msg.MultiContent {
+    if part.Type == openai.ChatMessagePartTypeText {
+      texts = append(texts, part.Text)
+    }
+  }
+  return strings.Join(texts, "\n")
+}
+
+// messageImages returns the image parts of a message.
+func messageImages(msg openai.ChatCompletionMessage) []openai.ChatMessagePart {
+  var images []openai.ChatMessagePart
+  for _, part := range msg.MultiContent {
+    if part.Type == openai.ChatMessagePartTypeImageURL {
+      images = append(images, part)
+    }
+  }
+  return images
+}
+
+// sameMessage reports whether two messages have the same role, text and
+// images.
+func sameMessage(x, y openai.ChatCompletionMessage) bool {
+  sameImage := func(a, b openai.ChatMessagePart) bool {
+    return a.ImageURL != nil && b.ImageURL != nil && *a.ImageURL == *b.ImageURL
+  }
+  return x.Role == y.Role && messageText(x) == messageText(y) &&
+    slices.EqualFunc(messageImages(x), messageImages(y), sameImage)
+}
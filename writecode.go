@@ -0,0 +1,88 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// firstCodeBlock returns the contents of the first fenced code block in a
+// markdown response. An unterminated block is treated as missing, since it
+// usually means the response was cut off.
+func firstCodeBlock(markdown string) (string, bool) {
+  var fence string
+  var body []string
+  for _, line := range strings.Split(markdown, "\n") {
+    trimmed := strings.TrimSpace(line)
+    if fence == "" {
+      if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+        fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+      }
+      continue
+    }
+    if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+      if len(body) == 0 {
+        return "", true
+      }
+      return strings.Join(body, "\n") + "\n", true
+    }
+    body = append(body, line)
+  }
+  return "", false
+}
+
+// writeFileAtomic replaces path with content by writing a temporary file in
+// the same directory and renaming it over the original, so the original is
+// untouched if anything fails. An existing file's permissions are kept. With
+// backup, the previous version is first copied to path+".bak", and the
+// backup path is returned.
+func writeFileAtomic(path, content string, backup bool) (string, error) {
+  mode := fs.FileMode(0644)
+  original, err := os.ReadFile(path)
+  exists := err == nil
+  if err != nil && !errors.Is(err, fs.ErrNotExist) {
+    return "", err
+  }
+  if exists {
+    if info, err := os.Stat(path); err == nil {
+      mode = info.Mode().Perm()
+    }
+  }
+
+  tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+  if err != nil {
+    return "", err
+  }
+  defer os.Remove(tmp.Name())
+
+  if _, err := tmp.WriteString(content); err != nil {
+    tmp.Close()
+    return "", err
+  }
+  if err := tmp.Sync(); err != nil {
+    tmp.Close()
+    return "", err
+  }
+  if err := tmp.Close(); err != nil {
+    return "", err
+  }
+  if err := os.Chmod(tmp.Name(), mode); err != nil {
+    return "", err
+  }
+
+  var backupPath string
+  if backup && exists {
+    backupPath = path + ".bak"
+    if err := os.WriteFile(backupPath, original, mode); err != nil {
+      return "", fmt.Errorf("writing backup: %w", err)
+    }
+  }
+
+  if err := os.Rename(tmp.Name(), path); err != nil {
+    return "", err
+  }
+  return backupPath, nil
+}